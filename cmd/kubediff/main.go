@@ -14,38 +14,131 @@ import (
 func main() {
 	var err error
 	d := &diff.Diff{}
-	var filename = pflag.StringSliceP("filename", "f", []string{}, "Filename or directory with files to compare")
+	var filename = pflag.StringSliceP("filename", "f", []string{}, "Filename or directory with files to compare, - to read from stdin")
+	pflag.StringVarP(&d.Kustomize, "kustomize", "k", "", "Process the kustomization directory, can be used with -f")
+	pflag.StringVar(&d.Helm.Path, "helm-chart", "", "Render local helm chart directory or tarball, can be used with -f")
+	pflag.StringSliceVar(&d.Helm.ValueFiles, "values", []string{}, "Values file for --helm-chart, can be repeated")
+	pflag.StringVar(&d.Helm.Release, "release", "release-name", "Release name for --helm-chart")
 	var recursive = pflag.BoolP("recursive", "R", false, "Process the directory used in -f, --filename recursively")
 	pflag.BoolVarP(&d.SkipSecrets, "skip-secrets", "", false, "Skip comparing of Secrets (no permission to read them)")
+	pflag.StringVar(&d.Sops.KeyFile, "age-key-file", "", "Age keys file to decrypt sops encrypted files (default $SOPS_AGE_KEY_FILE, $SOPS_AGE_KEY or sops keys.txt)")
+	pflag.BoolVar(&d.SecretsMetadata, "secrets-metadata", false, "Compare only metadata, type and key names of Secrets, listed via metadata API (no permission to read values)")
 	pflag.StringVar(&d.Cluster, "cluster", "", "The name of the kubeconfig cluster to use")
 	pflag.StringVar(&d.Context, "context", "", "The name of the kubeconfig context to use")
 	pflag.StringVar(&d.Kubeconfig, "kubeconfig", "", "Path to the kubeconfig file to use for CLI requests")
 	pflag.StringVarP(&d.Namespace, "namespace", "n", "", "If present, the namespace scope for this CLI request")
 	pflag.StringVar(&d.Token, "token", "", "Bearer token for authentication to the API server")
-	var filterfile = pflag.StringP("filter-file", "", "", "Path to a filter yml file to apply defaults before comparing (default built-in)")
+	pflag.StringVarP(&d.Output, "output", "o", "diff", "Output format: diff or json (one record per compared object)")
+	pflag.BoolVar(&d.Prune, "prune", false, "Report cluster objects matching --selector, which are missing in files (would be deleted)")
+	pflag.StringVarP(&d.Selector, "selector", "l", "", "Label selector to find objects to prune, used with --prune")
+	pflag.IntVarP(&d.Parallel, "parallel", "", 1, "Number of objects to fetch from the cluster concurrently")
+	pflag.BoolVar(&d.Prefetch, "prefetch", false, "List objects of the same kind and namespace in one request, instead of getting them one by one")
+	pflag.BoolVar(&d.ServerDryRun, "server-dry-run", false, "Compare result of server-side apply dry-run (needs patch permission), falls back to filter when forbidden")
+	pflag.StringVar(&d.FieldManager, "field-manager", diff.DefaultFieldManager, "Field manager of server-side dry-run, set it to the one used to deploy (e.g. kubectl for --server-side, helm) to see removed fields")
+	pflag.BoolVar(&d.OpenAPIDefaults, "openapi-defaults", false, "Set default values from the cluster OpenAPI v3 schema to missing fields of files, before applying filter")
+	pflag.BoolVar(&d.LocalDefaults, "local-defaults", false, "Set default values of built-in kinds (core, apps, batch, networking) to missing fields of files, like api server does, before applying filter")
+	pflag.StringVar(&d.AgainstSnapshot, "against-snapshot", "", "Compare with objects from snapshot directory or tarball, instead of the cluster")
+	var from = pflag.StringSlice("from", []string{}, "Filename or directory with old manifests, to compare with --to instead of the cluster")
+	var to = pflag.StringSlice("to", []string{}, "Filename or directory with new manifests, used with --from")
+	var gitBase = pflag.String("git-base", "", "Compare files with their version at git revision (branch, tag, commit) instead of the cluster")
+	var filterfiles = pflag.StringArray("filter-file", []string{}, "Path to a filter yml file to apply defaults before comparing, layered on top of built-in one, can be repeated")
+	var noBuiltinFilter = pflag.Bool("no-builtin-filter", false, "Do not use built-in filter, only --filter-file")
 	var ver = pflag.BoolP("version", "v", false, "Show version and exit")
+	pflag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags]                 compare files with the cluster\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s snapshot PATH [flags]   save cluster objects for the files to dir or .tar.gz\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --from DIR --to DIR     compare two sets of files without the cluster\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --git-base REF [flags]  compare files with their version at git revision\n", os.Args[0])
+		pflag.PrintDefaults()
+	}
 	pflag.Parse()
 	if *ver {
 		fmt.Println(version.Print("kubediff"))
 		os.Exit(0)
 	}
 
-	if len(*filename) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: must specify at least one filename\n")
+	if d.Output != "diff" && d.Output != "json" {
+		fmt.Fprintf(os.Stderr, "Error: unsupported output format %q\n", d.Output)
+		os.Exit(2)
+	}
+	if d.Parallel < 1 {
+		fmt.Fprintf(os.Stderr, "Error: --parallel must be at least 1\n")
+		os.Exit(2)
+	}
+	if d.SkipSecrets && d.SecretsMetadata {
+		fmt.Fprintf(os.Stderr, "Error: --skip-secrets and --secrets-metadata can't be used together\n")
+		os.Exit(2)
+	}
+	if d.Prune && d.Selector == "" {
+		fmt.Fprintf(os.Stderr, "Error: --prune requires --selector\n")
+		os.Exit(2)
+	}
+	compare := len(*from) > 0 || len(*to) > 0
+	switch {
+	case compare && (len(*from) == 0 || len(*to) == 0):
+		fmt.Fprintf(os.Stderr, "Error: --from and --to must be used together\n")
+		os.Exit(2)
+	case compare && (len(*filename) > 0 || d.Kustomize != "" || d.Helm.Path != ""):
+		fmt.Fprintf(os.Stderr, "Error: --from and --to can't be used with other inputs\n")
+		os.Exit(2)
+	case *gitBase != "" && (compare || d.Kustomize != "" || d.Helm.Path != ""):
+		fmt.Fprintf(os.Stderr, "Error: --git-base can only be used with -f\n")
+		os.Exit(2)
+	case !compare && len(*filename) == 0 && d.Kustomize == "" && d.Helm.Path == "":
+		fmt.Fprintf(os.Stderr, "Error: must specify at least one filename, kustomization directory or helm chart\n")
 		os.Exit(2)
 	}
 	if d.Files, err = store.ExpandToFilenames(*filename, *recursive); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read dir: %v\n", err)
 		os.Exit(2)
 	}
+	if *gitBase != "" {
+		compare = true
+		if d.GitBase, err = store.OpenGitRevision(*gitBase); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		if d.From, err = d.GitBase.ExpandToFilenames(*filename, *recursive); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read dir: %v\n", err)
+			os.Exit(2)
+		}
+		d.To = d.Files
+	} else {
+		if d.From, err = store.ExpandToFilenames(*from, *recursive); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read dir: %v\n", err)
+			os.Exit(2)
+		}
+		if d.To, err = store.ExpandToFilenames(*to, *recursive); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read dir: %v\n", err)
+			os.Exit(2)
+		}
+	}
 
-	d.Filter, err = filter.NewFilter(*filterfile)
+	d.Filter, err = filter.NewFilter(!*noBuiltinFilter, *filterfiles...)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read filter-file: %v\n", err)
 		os.Exit(2)
 	}
 
-	exitCode, err := d.Run()
+	var exitCode int
+	switch pflag.Arg(0) {
+	case "":
+		if compare {
+			exitCode, err = d.Compare(os.Stdout)
+		} else {
+			exitCode, err = d.Run()
+		}
+	case "snapshot":
+		if pflag.NArg() != 2 {
+			fmt.Fprintf(os.Stderr, "Error: snapshot requires exactly one PATH argument\n")
+			os.Exit(2)
+		}
+		exitCode, err = d.Snapshot(pflag.Arg(1))
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", pflag.Arg(0))
+		os.Exit(2)
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 	}
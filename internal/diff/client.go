@@ -7,26 +7,34 @@ import (
 	k8serr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
-func (d *Diff) getClients() (dynamic.Interface, discovery.DiscoveryInterface, error) {
+func (d *Diff) getClients() (*clusterSource, error) {
 	config, err := d.buildConfig()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to build config: %w", err)
+		return nil, fmt.Errorf("failed to build config: %w", err)
 	}
 
 	dynamicClient, err := dynamic.NewForConfig(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create dynamic client: %w", err)
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
 	}
 
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create discovery client: %w", err)
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
 	}
-	return dynamicClient, discoveryClient, nil
+
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metadata client: %w", err)
+	}
+	c := newClusterSource(dynamicClient, discoveryClient)
+	c.metadata = metadataClient
+	return c, nil
 }
 
 func (d *Diff) buildConfig() (*rest.Config, error) {
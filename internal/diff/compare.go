@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/sepich/kubediff/internal/store"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// compareLabels are names of the old and new sides in the rendered diff, when comparing two sets of files
+var compareLabels = [2]string{"from", "to"}
+
+// compareKey identifies object in a set of files
+type compareKey struct {
+	gvk       schema.GroupVersionKind
+	namespace string
+	name      string
+}
+
+// Compare diffs objects from d.From files (at d.GitBase revision if set) with d.To files without the cluster,
+// reporting objects which are changed or added in To, and then the ones removed from From, to w.
+func (d *Diff) Compare(w io.Writer) (int, error) {
+	open := store.Open
+	if d.GitBase != nil {
+		open = d.GitBase.Open
+	}
+	from, err := readFiles(open, d.From)
+	if err != nil {
+		return 2, fmt.Errorf("failed to process from files: %w", err)
+	}
+	to, err := readFiles(store.Open, d.To)
+	if err != nil {
+		return 2, fmt.Errorf("failed to process to files: %w", err)
+	}
+	if err := d.decryptItems(from); err != nil {
+		return 2, err
+	}
+	if err := d.decryptItems(to); err != nil {
+		return 2, err
+	}
+
+	fromItems := make(map[compareKey]item, len(from))
+	for _, it := range from {
+		key := d.compareKey(it.obj)
+		if _, ok := fromItems[key]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: duplicate object %s %s/%s in %s\n", key.gvk.Kind, key.namespace, key.name, it.source)
+		}
+		fromItems[key] = it
+	}
+
+	hasDiff := false
+	matched := make(map[compareKey]bool, len(to))
+	for _, it := range to {
+		key := d.compareKey(it.obj)
+		fromObj := &unstructured.Unstructured{}
+		decrypted := it.decrypted
+		if prev, ok := fromItems[key]; ok {
+			fromObj = prev.obj.DeepCopy()
+			decrypted = slices.Concat(prev.decrypted, decrypted)
+			matched[key] = true
+		}
+		res, err := d.compareObjects(fromObj, it.obj.DeepCopy(), decrypted)
+		if err != nil {
+			return 2, fmt.Errorf("failed to process file %s: failed to diff object %s/%s: %w", it.source, key.gvk.Kind, key.name, err)
+		}
+		res.Namespace = key.namespace // dropped by filter
+		res.Source = it.source
+		if err := d.report(w, res); err != nil {
+			return 2, fmt.Errorf("failed to write report: %w", err)
+		}
+		if res.HasDiff() {
+			hasDiff = true
+		}
+	}
+
+	for _, it := range from {
+		key := d.compareKey(it.obj)
+		if matched[key] {
+			continue
+		}
+		matched[key] = true // report duplicates once
+		res, err := deletedResult(it.obj.DeepCopy(), d.Filter, compareLabels, it.decrypted)
+		if err != nil {
+			return 2, fmt.Errorf("failed to process file %s: failed to diff object %s/%s: %w", it.source, key.gvk.Kind, key.name, err)
+		}
+		res.Namespace = key.namespace
+		res.Source = it.source
+		if err := d.report(w, res); err != nil {
+			return 2, fmt.Errorf("failed to write report: %w", err)
+		}
+		hasDiff = true
+	}
+
+	if hasDiff {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// compareKey returns key to match the object across sets of files, namespace defaults to the current one
+func (d *Diff) compareKey(obj *unstructured.Unstructured) compareKey {
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = d.Namespace
+	}
+	return compareKey{gvk: obj.GroupVersionKind(), namespace: namespace, name: obj.GetName()}
+}
+
+// compareObjects applies filter and diffs toObj against fromObj, empty fromObj means the object is added.
+// Values at decrypted paths are masked on both sides.
+func (d *Diff) compareObjects(fromObj, toObj *unstructured.Unstructured, decrypted [][]string) (*Result, error) {
+	d.Filter.Apply(toObj, fromObj)
+	return diffObjects(toObj, fromObj, compareLabels, decrypted)
+}
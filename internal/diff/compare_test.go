@@ -0,0 +1,89 @@
+package diff
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+)
+
+func TestCompare(t *testing.T) {
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) string {
+		fn := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return fn
+	}
+	from := write("from.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: same\n---\n"+
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: changed\ndata:\n  key: old\n---\n"+
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: removed\n")
+	to := write("to.yaml", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: changed\ndata:\n  key: new\n---\n"+
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: added\n---\n"+
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: same\n  namespace: default\n")
+
+	tests := []struct {
+		output   string
+		expected []string
+	}{
+		{
+			output: "json",
+			expected: []string{
+				`{"apiVersion":"v1","kind":"ConfigMap","namespace":"default","name":"changed","source":"` + to + `","status":"changed","changes":[{"path":"data.key","old":"old","new":"new"}]}`,
+				`{"apiVersion":"v1","kind":"ConfigMap","namespace":"default","name":"added","source":"` + to + `","status":"new","changes":[{"path":"apiVersion","new":"v1"},{"path":"kind","new":"ConfigMap"},{"path":"metadata","new":{"name":"added"}}]}`,
+				`{"apiVersion":"v1","kind":"ConfigMap","namespace":"default","name":"same","source":"` + to + `","status":"unchanged"}`,
+				`{"apiVersion":"v1","kind":"ConfigMap","namespace":"default","name":"removed","source":"` + from + `","status":"deleted","changes":[{"path":"apiVersion","old":"v1"},{"path":"kind","old":"ConfigMap"},{"path":"metadata","old":{"name":"removed"}}]}`,
+			},
+		},
+		{
+			output: "diff",
+			expected: []string{
+				"--- from/ConfigMap-changed.yaml",
+				"+++ to/ConfigMap-changed.yaml",
+				"@@ -1,6 +1,6 @@",
+				" apiVersion: v1",
+				" data:",
+				"-  key: old",
+				"+  key: new",
+				" kind: ConfigMap",
+				" metadata:",
+				"   name: changed",
+				"--- from/ConfigMap-added.yaml",
+				"+++ to/ConfigMap-added.yaml",
+				"@@ -0,0 +1,4 @@",
+				"+apiVersion: v1",
+				"+kind: ConfigMap",
+				"+metadata:",
+				"+  name: added",
+				"--- from/ConfigMap-removed.yaml",
+				"+++ to/ConfigMap-removed.yaml",
+				"@@ -1,4 +0,0 @@",
+				"-apiVersion: v1",
+				"-kind: ConfigMap",
+				"-metadata:",
+				"-  name: removed",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			d := &Diff{From: []string{from}, To: []string{to}, Namespace: "default", Output: tt.output, Filter: f}
+			var out bytes.Buffer
+			code, err := d.Compare(&out)
+			if err != nil || code != 1 {
+				t.Errorf("Compare() = %v, %v, expected 1, nil", code, err)
+			}
+			if got := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n"); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Compare() output =\n%s\nexpected\n%s", out.String(), strings.Join(tt.expected, "\n"))
+			}
+		})
+	}
+}
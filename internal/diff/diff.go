@@ -1,49 +1,95 @@
 package diff
 
 import (
-	"context"
+	"bytes"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/sepich/kubediff/internal/filter"
+	"github.com/sepich/kubediff/internal/store"
 
 	"k8s.io/apimachinery/pkg/api/errors"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/client-go/discovery"
-	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/util/retry"
 	kyaml "sigs.k8s.io/yaml"
 )
 
 type Diff struct {
 	Files           []string
+	Kustomize       string
+	Helm            store.HelmChart
+	Sops            store.Sops
 	Cluster         string
 	Context         string
 	Kubeconfig      string
 	Namespace       string
 	Token           string
 	SkipSecrets     bool
+	SecretsMetadata bool
+	Output          string
+	Prune           bool
+	Selector        string
+	Parallel        int
+	Prefetch        bool
+	ServerDryRun    bool
+	FieldManager    string // of server-side dry-run, DefaultFieldManager if empty
+	OpenAPIDefaults bool
+	LocalDefaults   bool
+	AgainstSnapshot string
+	From            []string // files of the old manifests set, to compare with To instead of the cluster
+	To              []string
+	GitBase         *store.GitRevision // read From files at this revision, if set
 	Filter          *filter.Filter
-	apiResourceList map[string]*metav1.APIResourceList
+	source          objectSource
+	cluster         *clusterSource // nil when not connected to the cluster
+	applied         map[objectKey]bool
+	pruneScopes     []pruneScope
+	mu              sync.Mutex // guards applied and pruneScopes
 }
 
 func (d *Diff) Run() (int, error) {
-	d.apiResourceList = make(map[string]*metav1.APIResourceList)
+	d.applied = make(map[objectKey]bool)
 
-	dynamicClient, discoveryClient, err := d.getClients()
+	if d.AgainstSnapshot != "" {
+		if d.Prune || d.Prefetch || d.ServerDryRun {
+			return 2, fmt.Errorf("prune, prefetch and server-dry-run need cluster access, and can't be used with snapshot")
+		}
+		snapshot, err := loadSnapshot(d.AgainstSnapshot)
+		if err != nil {
+			return 2, fmt.Errorf("failed to load snapshot: %w", err)
+		}
+		if d.Namespace == "" {
+			d.Namespace = snapshot.namespace
+		}
+		d.source = snapshot
+	} else {
+		var err error
+		if d.cluster, err = d.getClients(); err != nil {
+			return 2, fmt.Errorf("failed to get clients: %w", err)
+		}
+		d.source = d.cluster
+	}
+
+	items, err := d.readItems()
 	if err != nil {
-		return 2, fmt.Errorf("failed to get clients: %w", err)
+		return 2, err
 	}
 
-	hasDiff := false
-	for _, file := range d.Files {
-		diffFound, err := d.processFile(file, dynamicClient, discoveryClient)
+	if d.Prefetch {
+		d.prefetch(items)
+	}
+
+	hasDiff, err := d.diffItems(items)
+	if err != nil {
+		return 2, err
+	}
+
+	if d.Prune {
+		diffFound, err := d.prune()
 		if err != nil {
-			return 2, fmt.Errorf("failed to process file %s: %w", file, err)
+			return 2, fmt.Errorf("failed to find objects to prune: %w", err)
 		}
 		if diffFound {
 			hasDiff = true
@@ -56,69 +102,156 @@ func (d *Diff) Run() (int, error) {
 	return 0, nil
 }
 
-// diffObject compares a obj with the cluster state, and returns true if there are differences.
-func (d *Diff) diffObject(fileObj *unstructured.Unstructured, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface) (bool, error) {
+// readItems reads objects to compare from all the inputs
+func (d *Diff) readItems() ([]item, error) {
+	items, err := readFiles(store.Open, d.Files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process files: %w", err)
+	}
+	if d.Kustomize != "" {
+		kustomizeItems, err := readKustomization(d.Kustomize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process kustomization: %w", err)
+		}
+		items = append(items, kustomizeItems...)
+	}
+	if d.Helm.Path != "" {
+		helmItems, err := d.readHelmChart()
+		if err != nil {
+			return nil, fmt.Errorf("failed to process helm chart: %w", err)
+		}
+		items = append(items, helmItems...)
+	}
+
+	if err := d.decryptItems(items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// diffObject compares a obj with the cluster state, and returns the comparison result.
+// Values at decrypted paths are masked on both sides.
+func (d *Diff) diffObject(fileObj *unstructured.Unstructured, decrypted [][]string) (*Result, error) {
 	gvk := fileObj.GroupVersionKind()
 	if gvk.Kind == "Secret" && gvk.Group == "" && d.SkipSecrets {
 		fmt.Fprintf(os.Stderr, "Skipping Secret: %s/%s\n", d.Namespace, fileObj.GetName())
-		return false, nil
+		return newResult(fileObj, StatusSkipped), nil
 	}
 
-	gvr, isNamespaced, err := d.getGVRAndScope(gvk, discoveryClient)
+	gvr, isNamespaced, err := d.source.getGVRAndScope(gvk)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Warning: could not find GVR for %s: %v\n", gvk.String(), err)
+		namespace := d.resolveNamespace(fileObj, true)
 		// failed to get GVR means no CRD for this object yet, = full diff instead of error
-		return HasDiff(fileObj, &unstructured.Unstructured{})
+		res, err := diffObjects(fileObj, &unstructured.Unstructured{}, clusterLabels, decrypted)
+		if res != nil {
+			res.Namespace = namespace
+		}
+		return res, err
 	}
 
-	namespace := fileObj.GetNamespace()
-	if namespace == "" && d.Namespace != "" && isNamespaced {
-		namespace = d.Namespace
+	namespace := d.resolveNamespace(fileObj, isNamespaced)
+	if d.Prune {
+		d.markApplied(*gvr, gvk, isNamespaced, namespace, fileObj.GetName())
 	}
 
-	var resourceInterface dynamic.ResourceInterface
-	if isNamespaced && namespace != "" {
-		resourceInterface = dynamicClient.Resource(*gvr).Namespace(namespace)
-	} else {
-		resourceInterface = dynamicClient.Resource(*gvr)
+	if d.SecretsMetadata && d.cluster != nil && isSecret(fileObj) {
+		return d.diffSecretMetadata(fileObj, namespace)
 	}
 
-	var clusterObj *unstructured.Unstructured
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(120*time.Second))
-	defer cancel()
-	err = retry.OnError(retry.DefaultRetry, func(err error) bool {
-		if isRetriableError(err) {
-			fmt.Fprintf(os.Stderr, "Get resource %s/%s error: %v, will retry...\n", gvk.Kind, fileObj.GetName(), err)
-			return true
-		}
-		return false
-	}, func() error {
-		clusterObj, err = resourceInterface.Get(ctx, fileObj.GetName(), metav1.GetOptions{})
-		return err
-	})
+	clusterObj, err := d.source.getObject(*gvr, isNamespaced, namespace, fileObj.GetName())
 	if err != nil {
-		if errors.IsNotFound(err) {
-			clusterObj = &unstructured.Unstructured{}
-		} else {
-			return false, fmt.Errorf("failed to get object from cluster: %w", err)
+		res := newResult(fileObj, StatusError)
+		res.Namespace = namespace
+		return res, fmt.Errorf("failed to get object from cluster: %w", err)
+	}
+	if isSealedSecret(fileObj) {
+		return d.diffSealedSecret(fileObj, clusterObj, namespace)
+	}
+
+	if d.ServerDryRun && d.cluster != nil {
+		fieldManager := d.FieldManager
+		if fieldManager == "" {
+			fieldManager = DefaultFieldManager
 		}
+		dryRunObj, err := d.cluster.dryRun(*gvr, isNamespaced, namespace, fieldManager, fileObj)
+		switch {
+		case err == nil:
+			// server has already applied defaults and mutations, only cleanup is needed
+			d.Filter.Ignore(dryRunObj, clusterObj)
+			filter.Normalize(dryRunObj)
+			filter.Normalize(clusterObj)
+			fileObj = dryRunObj
+		case errors.IsForbidden(err):
+			fmt.Fprintf(os.Stderr, "Warning: server-side dry-run is forbidden for %s/%s, using filter instead\n", gvk.Kind, fileObj.GetName())
+			d.applyFilter(fileObj, clusterObj)
+		default:
+			res := newResult(fileObj, StatusError)
+			res.Namespace = namespace
+			return res, fmt.Errorf("failed to dry-run object: %w", err)
+		}
+	} else {
+		d.applyFilter(fileObj, clusterObj)
 	}
 
+	res, err := diffObjects(fileObj, clusterObj, clusterLabels, decrypted)
+	if res != nil && isNamespaced {
+		res.Namespace = namespace
+	}
+	return res, err
+}
+
+// applyFilter sets defaults of built-in kinds and from the cluster OpenAPI schema to fileObj if enabled, and then applies the filter
+func (d *Diff) applyFilter(fileObj, clusterObj *unstructured.Unstructured) {
+	if d.LocalDefaults {
+		if _, err := filter.SetDefaults(fileObj); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v, using filter only\n", err)
+		}
+	}
+	if d.OpenAPIDefaults && d.cluster != nil {
+		if err := d.cluster.openAPIDefaults(fileObj); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v, using filter only\n", err)
+		}
+	}
 	d.Filter.Apply(fileObj, clusterObj)
-	return HasDiff(fileObj, clusterObj)
 }
 
-// HasDiff runs the diff command on the provided file and cluster objects, and returns true if differences are found.
-func HasDiff(fileObj, clusterObj *unstructured.Unstructured) (bool, error) {
-	tmpDir, err := os.MkdirTemp("", "kubediff-")
-	if err != nil {
-		return false, fmt.Errorf("failed to create temp directory: %w", err)
+// resolveNamespace returns namespace of the object in the cluster, defaulting to the current namespace
+func (d *Diff) resolveNamespace(obj *unstructured.Unstructured, isNamespaced bool) string {
+	namespace := obj.GetNamespace()
+	if namespace == "" && d.Namespace != "" && isNamespaced {
+		namespace = d.Namespace
 	}
-	defer os.RemoveAll(tmpDir)
+	return namespace
+}
+
+func newResult(fileObj *unstructured.Unstructured, status Status) *Result {
+	return &Result{
+		APIVersion: fileObj.GetAPIVersion(),
+		Kind:       fileObj.GetKind(),
+		Namespace:  fileObj.GetNamespace(),
+		Name:       fileObj.GetName(),
+		Status:     status,
+	}
+}
+
+// HasDiff compares the provided file and cluster objects, and returns the result with rendered diff and changed fields.
+// Secret values are masked in both objects.
+func HasDiff(fileObj, clusterObj *unstructured.Unstructured) (*Result, error) {
+	return diffObjects(fileObj, clusterObj, clusterLabels, nil)
+}
 
+// diffObjects compares new object with the old one, using labels for the sides in the rendered diff.
+// Values at decrypted paths are masked like Secret values.
+func diffObjects(fileObj, clusterObj *unstructured.Unstructured, labels [2]string, decrypted [][]string) (*Result, error) {
+	res := newResult(fileObj, StatusUnchanged)
+	maskSecret(fileObj, clusterObj)
+	alignLists(fileObj.Object, clusterObj.Object)
+	maskDecrypted(fileObj, clusterObj, decrypted)
 	fileYAML, err := kyaml.Marshal(fileObj.Object)
 	if err != nil {
-		return false, fmt.Errorf("failed to marshal file object: %w", err)
+		res.Status = StatusError
+		return res, fmt.Errorf("failed to marshal file object: %w", err)
 	}
 
 	clusterYAML := []byte{}
@@ -126,53 +259,88 @@ func HasDiff(fileObj, clusterObj *unstructured.Unstructured) (bool, error) {
 		var err error
 		clusterYAML, err = kyaml.Marshal(clusterObj.Object)
 		if err != nil {
-			return false, fmt.Errorf("failed to marshal cluster object: %w", err)
+			res.Status = StatusError
+			return res, fmt.Errorf("failed to marshal cluster object: %w", err)
 		}
 	}
 
-	fn := strings.ReplaceAll(fileObj.GetKind()+"-"+fileObj.GetName(), ":", "-")
+	var changed bool
+	res.Diff, changed, err = renderDiff(fileObj, labels, fileYAML, clusterYAML)
+	if err != nil {
+		res.Status = StatusError
+		return res, err
+	}
+	if !changed {
+		return res, nil
+	}
+	res.Status = StatusChanged
+	if len(clusterObj.Object) == 0 {
+		res.Status = StatusNew
+	}
+	res.Changes = compareValues("", map[string]any(clusterObj.Object), map[string]any(fileObj.Object), nil)
+	return res, nil
+}
+
+// clusterLabels are names of the old and new sides in the rendered diff, when comparing with the cluster
+var clusterLabels = [2]string{"cluster", "file"}
+
+// renderDiff returns diff output for the marshalled objects, and true if differences are found.
+// Built-in unified diff is used, unless external command is set via KUBECTL_EXTERNAL_DIFF env.
+func renderDiff(obj *unstructured.Unstructured, labels [2]string, fileYAML, clusterYAML []byte) (string, bool, error) {
+	fn := strings.ReplaceAll(obj.GetKind()+"-"+obj.GetName(), ":", "-")
+	if diffCmd := os.Getenv("KUBECTL_EXTERNAL_DIFF"); diffCmd != "" {
+		return externalDiff(diffCmd, fn, fileYAML, clusterYAML)
+	}
+	out := unifiedDiff(labels[0]+"/"+fn+".yaml", labels[1]+"/"+fn+".yaml", string(clusterYAML), string(fileYAML))
+	return out, out != "", nil
+}
+
+// externalDiff dumps objects to temp files and runs the diff command on them, returns its output and true if differences are found
+func externalDiff(diffCmd, fn string, fileYAML, clusterYAML []byte) (string, bool, error) {
+	tmpDir, err := os.MkdirTemp("", "kubediff-")
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
 	fileTemp, err := os.Create(fmt.Sprintf("%s/f-%s.yaml", tmpDir, fn))
 	if err != nil {
-		return false, fmt.Errorf("failed to create temp file: %w", err)
+		return "", false, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(fileTemp.Name())
 	defer fileTemp.Close()
 
 	clusterTemp, err := os.Create(fmt.Sprintf("%s/c-%s.yaml", tmpDir, fn))
 	if err != nil {
-		return false, fmt.Errorf("failed to create temp file: %w", err)
+		return "", false, fmt.Errorf("failed to create temp file: %w", err)
 	}
 	defer os.Remove(clusterTemp.Name())
 	defer clusterTemp.Close()
 
 	if _, err := fileTemp.Write(fileYAML); err != nil {
-		return false, fmt.Errorf("failed to write file yaml: %w", err)
+		return "", false, fmt.Errorf("failed to write file yaml: %w", err)
 	}
 
 	if _, err := clusterTemp.Write(clusterYAML); err != nil {
-		return false, fmt.Errorf("failed to write cluster yaml: %w", err)
-	}
-
-	diffCmd := os.Getenv("KUBECTL_EXTERNAL_DIFF")
-	if diffCmd == "" {
-		diffCmd = "diff -u -N"
+		return "", false, fmt.Errorf("failed to write cluster yaml: %w", err)
 	}
 
 	parts := strings.Fields(diffCmd)
 	cmd := exec.Command(parts[0], append(parts[1:], clusterTemp.Name(), fileTemp.Name())...)
-	cmd.Stdout = os.Stdout
+	var out bytes.Buffer
+	cmd.Stdout = &out
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
 		if exitError, ok := err.(*exec.ExitError); ok {
 			if exitError.ExitCode() == 1 {
 				// Exit code 1 means differences found
-				return true, nil
+				return out.String(), true, nil
 			}
 		}
-		return false, fmt.Errorf("diff command failed: %w", err)
+		return "", false, fmt.Errorf("diff command failed: %w", err)
 	}
 
 	// Exit code 0 means no differences
-	return false, nil
+	return out.String(), false, nil
 }
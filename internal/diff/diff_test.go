@@ -2,9 +2,19 @@ package diff
 
 import (
 	"os"
+	"reflect"
+	"sync"
 	"testing"
 
+	"github.com/sepich/kubediff/internal/filter"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
 )
 
 func TestExecuteDiff(t *testing.T) {
@@ -118,7 +128,8 @@ func TestExecuteDiff(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			os.Setenv("KUBECTL_EXTERNAL_DIFF", tt.envDiffCmd)
-			gotDiff, gotErr := HasDiff(fileObj, tt.clusterObj)
+			res, gotErr := HasDiff(fileObj, tt.clusterObj)
+			gotDiff := res.HasDiff()
 			if tt.expectedError && gotErr == nil {
 				t.Errorf("%s: HasDiff() expected error but got nil", tt.name)
 			}
@@ -131,3 +142,169 @@ func TestExecuteDiff(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareValues(t *testing.T) {
+	oldObj := map[string]any{
+		"metadata": map[string]any{
+			"name":   "test",
+			"labels": map[string]any{"app.kubernetes.io/name": "a"},
+		},
+		"spec": map[string]any{
+			"replicas": int64(1),
+			"ports":    []any{map[string]any{"port": int64(80)}, map[string]any{"port": int64(8080)}},
+			"args":     []any{"a"},
+		},
+	}
+	newObj := map[string]any{
+		"metadata": map[string]any{
+			"name":   "test",
+			"labels": map[string]any{"app.kubernetes.io/name": "b"},
+		},
+		"spec": map[string]any{
+			"ports":    []any{map[string]any{"port": int64(443)}, map[string]any{"port": int64(80), "protocol": "TCP"}},
+			"args":     []any{"a", "b"},
+			"selector": map[string]any{"app": "test"},
+		},
+	}
+	expected := []Change{
+		{Path: "metadata.labels['app.kubernetes.io/name']", Old: "a", New: "b"},
+		{Path: "spec.args[1]", New: "b"},
+		{Path: "spec.ports[port=443,protocol=TCP]", New: map[string]any{"port": int64(443)}},
+		{Path: "spec.ports[port=80,protocol=TCP].protocol", New: "TCP"},
+		{Path: "spec.ports[port=8080,protocol=TCP]", Old: map[string]any{"port": int64(8080)}},
+		{Path: "spec.replicas", Old: int64(1)},
+		{Path: "spec.selector", New: map[string]any{"app": "test"}},
+	}
+	got := compareValues("", oldObj, newObj, nil)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("compareValues() got: %v, expected: %v", got, expected)
+	}
+}
+
+func TestDiffItems(t *testing.T) {
+	cm := func(name, value string) *unstructured.Unstructured {
+		return configMap(name, map[string]any{"key": value}, nil)
+	}
+	cluster, _ := newFakeCluster([]fakeResource{configMaps}, cm("cm0", "value"), cm("cm1", "value"), cm("cm2", "value"))
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		items   []item
+		hasDiff bool
+	}{
+		{
+			name:    "no differences",
+			items:   []item{{obj: cm("cm0", "value"), source: "a.yml"}, {obj: cm("cm1", "value"), source: "a.yml"}, {obj: cm("cm2", "value"), source: "b.yml"}},
+			hasDiff: false,
+		},
+		{
+			name:    "changed and new objects",
+			items:   []item{{obj: cm("cm0", "value"), source: "a.yml"}, {obj: cm("cm1", "changed"), source: "a.yml"}, {obj: cm("cm3", "value"), source: "b.yml"}},
+			hasDiff: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Diff{Parallel: 4, Filter: f, cluster: cluster, source: cluster}
+			hasDiff, err := d.diffItems(tt.items)
+			if err != nil {
+				t.Fatalf("diffItems() unexpected error = %v", err)
+			}
+			if hasDiff != tt.hasDiff {
+				t.Errorf("diffItems() diff = %v, expected %v", hasDiff, tt.hasDiff)
+			}
+		})
+	}
+}
+
+func TestGetGVRAndScope(t *testing.T) {
+	cluster, _ := newFakeCluster([]fakeResource{configMaps, services})
+	tests := []struct {
+		name    string
+		gvk     schema.GroupVersionKind
+		gvr     *schema.GroupVersionResource
+		wantErr bool
+	}{
+		{name: "known kind", gvk: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}, gvr: &configMaps.gvr},
+		{name: "same group version", gvk: schema.GroupVersionKind{Version: "v1", Kind: "Service"}, gvr: &services.gvr},
+		{name: "unknown kind", gvk: schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, wantErr: true},
+		{name: "unknown group version", gvk: schema.GroupVersionKind{Group: "example.io", Version: "v1", Kind: "Widget"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			for range 5 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					gvr, _, err := cluster.getGVRAndScope(tt.gvk)
+					if (err != nil) != tt.wantErr {
+						t.Errorf("getGVRAndScope() error = %v, expected error %v", err, tt.wantErr)
+					}
+					if !reflect.DeepEqual(gvr, tt.gvr) {
+						t.Errorf("getGVRAndScope() = %v, expected %v", gvr, tt.gvr)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+	// resources are fetched once per group version
+	if actions := cluster.discovery.(*fakediscovery.FakeDiscovery).Actions(); len(actions) != 2 {
+		t.Errorf("discovery called %d times, expected 2", len(actions))
+	}
+}
+
+// fakeResource is api resource served by the fake cluster
+type fakeResource struct {
+	gvr        schema.GroupVersionResource
+	kind       string
+	namespaced bool
+}
+
+var (
+	configMaps = fakeResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, kind: "ConfigMap", namespaced: true}
+	secrets    = fakeResource{gvr: secretsGVR, kind: "Secret", namespaced: true}
+	services   = fakeResource{gvr: schema.GroupVersionResource{Version: "v1", Resource: "services"}, kind: "Service", namespaced: true}
+)
+
+// newFakeCluster returns cluster source with fake discovery and dynamic clients serving the resources and objects.
+// The dynamic client is returned to add reactors.
+func newFakeCluster(resources []fakeResource, objects ...runtime.Object) (*clusterSource, *fake.FakeDynamicClient) {
+	listKinds := make(map[schema.GroupVersionResource]string, len(resources))
+	byGV := make(map[string]*metav1.APIResourceList)
+	var lists []*metav1.APIResourceList
+	for _, r := range resources {
+		listKinds[r.gvr] = r.kind + "List"
+		gv := r.gvr.GroupVersion().String()
+		if byGV[gv] == nil {
+			byGV[gv] = &metav1.APIResourceList{GroupVersion: gv}
+			lists = append(lists, byGV[gv])
+		}
+		byGV[gv].APIResources = append(byGV[gv].APIResources, metav1.APIResource{Name: r.gvr.Resource, Kind: r.kind, Namespaced: r.namespaced})
+	}
+	dynamicClient := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...)
+	discoveryClient := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: lists}}
+	return newClusterSource(dynamicClient, discoveryClient), dynamicClient
+}
+
+// configMap returns ConfigMap in the default namespace, data and labels are set when not nil
+func configMap(name string, data, labels map[string]any) *unstructured.Unstructured {
+	metadata := map[string]any{"name": name, "namespace": "default"}
+	if labels != nil {
+		metadata["labels"] = labels
+	}
+	obj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata,
+	}}
+	if data != nil {
+		obj.Object["data"] = data
+	}
+	return obj
+}
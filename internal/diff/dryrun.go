@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+// DefaultFieldManager is the field manager of kubectl apply, same as kubectl diff uses
+const DefaultFieldManager = "kubectl-client-side-apply"
+
+// dryRun submits the object as server-side apply with dryRun=All, and returns the object as it would be persisted.
+// Fields missing in the object are removed only when they are owned by fieldManager.
+func (c *clusterSource) dryRun(gvr schema.GroupVersionResource, isNamespaced bool, namespace, fieldManager string, fileObj *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	obj := fileObj.DeepCopy()
+	if isNamespaced && namespace != "" {
+		obj.SetNamespace(namespace)
+	}
+	resourceInterface := c.resourceInterface(gvr, isNamespaced, namespace)
+
+	var res *unstructured.Unstructured
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(120*time.Second))
+	defer cancel()
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		if isRetriableError(err) {
+			fmt.Fprintf(os.Stderr, "Dry-run resource %s/%s error: %v, will retry...\n", gvr.Resource, obj.GetName(), err)
+			return true
+		}
+		return false
+	}, func() error {
+		var err error
+		res, err = resourceInterface.Apply(ctx, obj.GetName(), obj, metav1.ApplyOptions{
+			DryRun:       []string{metav1.DryRunAll},
+			FieldManager: fieldManager,
+			Force:        true,
+		})
+		return err
+	})
+	return res, err
+}
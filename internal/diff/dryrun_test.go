@@ -0,0 +1,136 @@
+package diff
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestServerDryRun(t *testing.T) {
+	svc := func(withDefaults bool) *unstructured.Unstructured {
+		port := map[string]any{"port": int64(80)}
+		if withDefaults {
+			port["protocol"] = "TCP"
+			port["targetPort"] = int64(80)
+		}
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]any{"name": "svc", "namespace": "default"},
+			"spec":       map[string]any{"ports": []any{port}},
+		}}
+	}
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		forbidden bool
+		hasDiff   bool
+	}{
+		{name: "dry-run applies defaults", forbidden: false, hasDiff: false},
+		{name: "forbidden falls back to filter", forbidden: true, hasDiff: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, dynamicClient := newFakeCluster([]fakeResource{services}, svc(true))
+			dynamicClient.PrependReactor("patch", "services", func(clienttesting.Action) (bool, runtime.Object, error) {
+				if tt.forbidden {
+					return true, nil, errors.NewForbidden(services.gvr.GroupResource(), "svc", nil)
+				}
+				return true, svc(true), nil
+			})
+			d := &Diff{ServerDryRun: true, Filter: f, cluster: cluster, source: cluster}
+			res, err := d.diffObject(svc(false), nil)
+			if err != nil {
+				t.Fatalf("diffObject() unexpected error = %v", err)
+			}
+			if res.HasDiff() != tt.hasDiff {
+				t.Errorf("diffObject() diff = %v, expected %v", res.HasDiff(), tt.hasDiff)
+			}
+		})
+	}
+}
+
+func TestServerDryRunFieldManager(t *testing.T) {
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterObj := configMap("cm", map[string]any{"a": "1", "b": "2"}, nil)
+	fileObj := configMap("cm", map[string]any{"a": "1"}, nil)
+
+	tests := []struct {
+		name         string
+		fieldManager string
+		hasDiff      bool
+	}{
+		{name: "owner removes field", fieldManager: "helm", hasDiff: true},
+		{name: "other manager keeps field", fieldManager: "", hasDiff: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, dynamicClient := newFakeCluster([]fakeResource{configMaps}, clusterObj.DeepCopy())
+			applied := &metav1.ApplyOptions{}
+			cluster.dynamic = applyRecorder{Interface: cluster.dynamic, options: applied}
+			// server-side apply removes fields missing in the object only for their owner, which is helm here
+			dynamicClient.PrependReactor("patch", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+				if applied.FieldManager == "helm" {
+					return true, fileObj.DeepCopy(), nil
+				}
+				return true, clusterObj.DeepCopy(), nil
+			})
+			d := &Diff{ServerDryRun: true, FieldManager: tt.fieldManager, Filter: f, cluster: cluster, source: cluster}
+			res, err := d.diffObject(fileObj.DeepCopy(), nil)
+			if err != nil {
+				t.Fatalf("diffObject() unexpected error = %v", err)
+			}
+			if res.HasDiff() != tt.hasDiff {
+				t.Errorf("diffObject() diff = %v, expected %v\n%s", res.HasDiff(), tt.hasDiff, res.Diff)
+			}
+			if tt.fieldManager == "" && applied.FieldManager != DefaultFieldManager {
+				t.Errorf("dryRun() field manager = %q, expected %q", applied.FieldManager, DefaultFieldManager)
+			}
+		})
+	}
+}
+
+// applyRecorder records options of Apply calls, which the fake dynamic client does not pass to reactors
+type applyRecorder struct {
+	dynamic.Interface
+	options *metav1.ApplyOptions
+}
+
+func (r applyRecorder) Resource(gvr schema.GroupVersionResource) dynamic.NamespaceableResourceInterface {
+	return applyRecorderResource{NamespaceableResourceInterface: r.Interface.Resource(gvr), options: r.options}
+}
+
+type applyRecorderResource struct {
+	dynamic.NamespaceableResourceInterface
+	options *metav1.ApplyOptions
+}
+
+func (r applyRecorderResource) Namespace(ns string) dynamic.ResourceInterface {
+	return applyRecorderNamespaced{ResourceInterface: r.NamespaceableResourceInterface.Namespace(ns), options: r.options}
+}
+
+type applyRecorderNamespaced struct {
+	dynamic.ResourceInterface
+	options *metav1.ApplyOptions
+}
+
+func (r applyRecorderNamespaced) Apply(ctx context.Context, name string, obj *unstructured.Unstructured, options metav1.ApplyOptions, subresources ...string) (*unstructured.Unstructured, error) {
+	*r.options = options
+	return r.ResourceInterface.Apply(ctx, name, obj, options, subresources...)
+}
@@ -1,31 +1,206 @@
 package diff
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/sepich/kubediff/internal/store"
-	"k8s.io/client-go/discovery"
-	"k8s.io/client-go/dynamic"
+	"io"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"os"
 )
 
-func (d *Diff) processFile(filename string, dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface) (bool, error) {
-	f, err := os.Open(filename)
+// item is an object read from a file to compare
+type item struct {
+	obj       *unstructured.Unstructured
+	source    string
+	raw       []byte     // YAML document of sops encrypted object, to verify MAC
+	decrypted [][]string // paths of values decrypted by sops, masked in diff
+}
+
+type itemResult struct {
+	res *Result
+	err error
+}
+
+// opener opens the file for reading, like store.Open
+type opener func(filename string) (io.ReadCloser, string, error)
+
+func readFile(open opener, filename string) ([]item, error) {
+	f, source, err := open(filename)
 	if err != nil {
-		return false, fmt.Errorf("failed to open file %s: %w", filename, err)
+		return nil, fmt.Errorf("failed to open file %s: %w", source, err)
 	}
+	defer f.Close()
 
-	hasDiff := false
-	for obj := range store.YamlToObj(f) {
-		if obj == nil {
-			return false, errors.New("failed to decode YAML")
+	var items []item
+	r := yaml.NewYAMLReader(bufio.NewReader(f))
+	for {
+		doc, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
 		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read YAML from %s: %w", source, err)
+		}
+		for obj := range store.YamlToObj(bytes.NewReader(doc)) {
+			if obj == nil {
+				return nil, fmt.Errorf("failed to decode YAML from %s", source)
+			}
+			it := item{obj: obj, source: source}
+			if store.IsSopsEncrypted(obj) {
+				it.raw = doc
+			}
+			items = append(items, it)
+		}
+	}
+	return items, nil
+}
 
-		diffFound, err := d.diffObject(obj, dynamicClient, discoveryClient)
+// readFiles reads items from all the files in order
+func readFiles(open opener, files []string) ([]item, error) {
+	var items []item
+	for _, file := range files {
+		fileItems, err := readFile(open, file)
 		if err != nil {
-			return false, fmt.Errorf("failed to diff object %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+			return nil, err
+		}
+		items = append(items, fileItems...)
+	}
+	return items, nil
+}
+
+// readKustomization builds kustomization dir to items, with source set to origin file of each object
+func readKustomization(dir string) ([]item, error) {
+	objs, sources, err := store.Kustomize(dir)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]item, len(objs))
+	for i, obj := range objs {
+		items[i] = item{obj: obj, source: sources[i]}
+	}
+	return items, nil
+}
+
+// readHelmChart renders helm chart to items, with source set to template file of each object.
+// Release namespace defaults to the current namespace, and capabilities are taken from the cluster.
+func (d *Diff) readHelmChart() ([]item, error) {
+	chart := d.Helm
+	if chart.Namespace == "" {
+		chart.Namespace = d.Namespace
+	}
+	if d.cluster != nil {
+		if v, err := d.cluster.discovery.ServerVersion(); err == nil {
+			chart.KubeVersion = v.GitVersion
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: failed to get server version: %v\n", err)
+		}
+		if groups, err := d.cluster.discovery.ServerGroups(); err == nil {
+			for _, g := range groups.Groups {
+				for _, v := range g.Versions {
+					chart.APIVersions = append(chart.APIVersions, v.GroupVersion)
+				}
+			}
+		}
+	}
+
+	objs, sources, err := chart.Render()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]item, len(objs))
+	for i, obj := range objs {
+		items[i] = item{obj: obj, source: sources[i]}
+	}
+	return items, nil
+}
+
+// decryptItems decrypts sops encrypted items in place, and records paths of decrypted values to mask them in diff.
+// MAC covers all the documents of a file, so it is verified once for consecutive items from the same source.
+// Objects built by kustomize or helm have no source documents to verify MAC, and are rejected.
+func (d *Diff) decryptItems(items []item) error {
+	for i := 0; i < len(items); {
+		if !store.IsSopsEncrypted(items[i].obj) {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(items) && items[j].source == items[i].source && store.IsSopsEncrypted(items[j].obj) {
+			j++
+		}
+		docs := make([][]byte, 0, j-i)
+		for _, it := range items[i:j] {
+			if it.raw == nil {
+				return fmt.Errorf("failed to decrypt %s/%s from %s: sops MAC can't be verified for generated objects, decrypt the file before building", it.obj.GetKind(), it.obj.GetName(), it.source)
+			}
+			docs = append(docs, it.raw)
+		}
+		if err := d.Sops.VerifyMAC(docs); err != nil {
+			return fmt.Errorf("failed to decrypt %s: %w", items[i].source, err)
+		}
+		for ; i < j; i++ {
+			it := &items[i]
+			paths, err := d.Sops.Decrypt(it.obj)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt %s/%s from %s: %w", it.obj.GetKind(), it.obj.GetName(), it.source, err)
+			}
+			it.decrypted = paths
+		}
+	}
+	return nil
+}
+
+// diffItems compares items with the cluster using d.Parallel workers, and reports results in the order of items.
+// Returns true if any differences are found.
+func (d *Diff) diffItems(items []item) (bool, error) {
+	results := make([]chan itemResult, len(items))
+	for i := range results {
+		results[i] = make(chan itemResult, 1)
+	}
+
+	jobs := make(chan int)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(jobs)
+		for i := range items {
+			select {
+			case jobs <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	for range max(d.Parallel, 1) {
+		go func() {
+			for i := range jobs {
+				res, err := d.diffObject(items[i].obj, items[i].decrypted)
+				results[i] <- itemResult{res, err}
+			}
+		}()
+	}
+
+	hasDiff := false
+	for i, ch := range results {
+		r := <-ch
+		obj := items[i].obj
+		if r.res != nil {
+			r.res.Source = items[i].source
+			if r.err != nil {
+				r.res.Status = StatusError
+				r.res.Error = r.err.Error()
+			}
+			if err := d.report(os.Stdout, r.res); err != nil {
+				return false, fmt.Errorf("failed to write report: %w", err)
+			}
+		}
+		if r.err != nil {
+			return false, fmt.Errorf("failed to process file %s: failed to diff object %s/%s: %w", items[i].source, obj.GetKind(), obj.GetName(), r.err)
 		}
-		if diffFound {
+		if r.res.HasDiff() {
 			hasDiff = true
 		}
 	}
@@ -0,0 +1,69 @@
+package diff
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestReadKustomization(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"kustomization.yaml": "namespace: default\nresources:\n- svc.yaml\ncommonLabels: {app: web}\n",
+		"svc.yaml":           "apiVersion: v1\nkind: Service\nmetadata:\n  name: svc\nspec:\n  ports: [{port: 80, protocol: TCP, targetPort: 8080}]\n",
+	}
+	for fn, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	items, err := readKustomization(dir)
+	if err != nil {
+		t.Fatalf("readKustomization() unexpected error = %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("readKustomization() got %d items, expected 1", len(items))
+	}
+	clusterObj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata":   map[string]any{"name": "svc", "namespace": "default", "labels": map[string]any{"app": "api"}},
+		"spec": map[string]any{
+			"ports":    []any{map[string]any{"port": int64(80), "protocol": "TCP", "targetPort": int64(8080)}},
+			"selector": map[string]any{"app": "web"},
+		},
+	}}
+	expectedChanges := []Change{{Path: "metadata.labels.app", Old: "api", New: "web"}}
+
+	res, err := HasDiff(items[0].obj.DeepCopy(), clusterObj.DeepCopy())
+	if err != nil {
+		t.Fatalf("HasDiff() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(res.Changes, expectedChanges) {
+		t.Errorf("HasDiff() changes = %v, expected %v", res.Changes, expectedChanges)
+	}
+
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster, dynamicClient := newFakeCluster([]fakeResource{services}, clusterObj.DeepCopy())
+	dynamicClient.PrependReactor("patch", "services", func(clienttesting.Action) (bool, runtime.Object, error) {
+		return true, items[0].obj.DeepCopy(), nil
+	})
+	d := &Diff{ServerDryRun: true, Filter: f, cluster: cluster, source: cluster}
+	res, err = d.diffObject(items[0].obj.DeepCopy(), nil)
+	if err != nil {
+		t.Fatalf("diffObject() unexpected error = %v", err)
+	}
+	if !reflect.DeepEqual(res.Changes, expectedChanges) {
+		t.Errorf("diffObject() changes = %v, expected %v", res.Changes, expectedChanges)
+	}
+}
@@ -1,36 +1,93 @@
 package diff
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/openapi"
 	"k8s.io/client-go/util/retry"
 )
 
-func (d Diff) getGVRAndScope(gvk schema.GroupVersionKind, discoveryClient discovery.DiscoveryInterface) (*schema.GroupVersionResource, bool, error) {
+// objectSource provides "cluster" objects to compare files with
+type objectSource interface {
+	// getGVRAndScope returns resource of the kind, and true if it is namespaced
+	getGVRAndScope(gvk schema.GroupVersionKind) (*schema.GroupVersionResource, bool, error)
+	// getObject returns the object, or empty object if not found
+	getObject(gvr schema.GroupVersionResource, isNamespaced bool, namespace, name string) (*unstructured.Unstructured, error)
+}
+
+// clusterSource reads objects from the cluster
+type clusterSource struct {
+	dynamic          dynamic.Interface
+	discovery        discovery.DiscoveryInterface
+	apiResourceList  map[string]*apiResourceEntry // resources by group version
+	prefetched       map[cacheKey]*unstructured.Unstructured
+	mu               sync.Mutex // guards apiResourceList map, resources are fetched outside of it
+	metadata         metadata.Interface
+	secrets          map[string]*secretsList               // secrets metadata by namespace
+	secretsMu        sync.Mutex                            // guards secrets map, lists are loaded outside of it
+	openAPIDocs      map[schema.GroupVersion]*openAPIEntry // documents by group version
+	openAPIMu        sync.Mutex                            // guards openAPIDocs map, documents are fetched outside of it
+	openAPIPaths     map[string]openapi.GroupVersion       // fetched once for all group versions
+	openAPIPathsErr  error
+	openAPIPathsOnce sync.Once
+}
+
+func newClusterSource(dynamicClient dynamic.Interface, discoveryClient discovery.DiscoveryInterface) *clusterSource {
+	return &clusterSource{
+		dynamic:         dynamicClient,
+		discovery:       discoveryClient,
+		apiResourceList: make(map[string]*apiResourceEntry),
+	}
+}
+
+// apiResourceEntry is api resources of a group version, fetched once
+type apiResourceEntry struct {
+	once sync.Once
+	list *metav1.APIResourceList
+	err  error
+}
+
+// getGVRAndScope returns resource of the kind and whether it is namespaced.
+// Resources are fetched once per group version, concurrent calls for the same group version wait for it.
+func (c *clusterSource) getGVRAndScope(gvk schema.GroupVersionKind) (*schema.GroupVersionResource, bool, error) {
 	key := gvk.GroupVersion().String()
-	res, ok := d.apiResourceList[key]
+	c.mu.Lock()
+	entry, ok := c.apiResourceList[key]
+	if !ok {
+		entry = &apiResourceEntry{}
+		c.apiResourceList[key] = entry
+	}
+	c.mu.Unlock()
 
 	// cache response for all further diff objects
-	if !ok {
-		var err error
-		err = retry.OnError(retry.DefaultRetry, func(err error) bool {
+	entry.once.Do(func() {
+		entry.err = retry.OnError(retry.DefaultRetry, func(err error) bool {
 			if isRetriableError(err) {
 				fmt.Fprintf(os.Stderr, "Get resources for %s error: %v, will retry...\n", key, err)
 				return true
 			}
 			return false
 		}, func() error {
-			res, err = discoveryClient.ServerResourcesForGroupVersion(key)
+			var err error
+			entry.list, err = c.discovery.ServerResourcesForGroupVersion(key)
 			return err
 		})
-		if err != nil {
-			return nil, false, err
-		}
-		d.apiResourceList[key] = res
+	})
+	if entry.err != nil {
+		return nil, false, entry.err
 	}
+	res := entry.list
 
 	for _, resource := range res.APIResources {
 		if resource.Kind == gvk.Kind {
@@ -44,3 +101,35 @@ func (d Diff) getGVRAndScope(gvk schema.GroupVersionKind, discoveryClient discov
 
 	return nil, false, fmt.Errorf("resource not found for kind %s", gvk.Kind)
 }
+
+func (c *clusterSource) getObject(gvr schema.GroupVersionResource, isNamespaced bool, namespace, name string) (*unstructured.Unstructured, error) {
+	if obj, ok := c.fromPrefetch(gvr, isNamespaced, namespace, name); ok {
+		return obj, nil
+	}
+
+	var obj *unstructured.Unstructured
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(120*time.Second))
+	defer cancel()
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		if isRetriableError(err) {
+			fmt.Fprintf(os.Stderr, "Get resource %s/%s error: %v, will retry...\n", gvr.Resource, name, err)
+			return true
+		}
+		return false
+	}, func() error {
+		var err error
+		obj, err = c.resourceInterface(gvr, isNamespaced, namespace).Get(ctx, name, metav1.GetOptions{})
+		return err
+	})
+	if errors.IsNotFound(err) {
+		return &unstructured.Unstructured{}, nil
+	}
+	return obj, err
+}
+
+func (c *clusterSource) resourceInterface(gvr schema.GroupVersionResource, isNamespaced bool, namespace string) dynamic.ResourceInterface {
+	if isNamespaced && namespace != "" {
+		return c.dynamic.Resource(gvr).Namespace(namespace)
+	}
+	return c.dynamic.Resource(gvr)
+}
@@ -0,0 +1,89 @@
+package diff
+
+import (
+	"github.com/sepich/kubediff/internal/filter"
+)
+
+// compareKeyedList collects differences of list elements matched by list keys, with paths like containers[name=app].
+// Returns false if the list elements have no keys, to compare them by index.
+func compareKeyedList(path, field string, oldList, newList []any, changes []Change) ([]Change, bool) {
+	oldKeys, ok := filter.ListKeys(field, oldList)
+	if !ok {
+		return changes, false
+	}
+	newKeys, ok := filter.ListKeys(field, newList)
+	if !ok {
+		return changes, false
+	}
+
+	oldByKey := make(map[string]any, len(oldList))
+	for i, key := range oldKeys {
+		oldByKey[key] = oldList[i]
+	}
+	newByKey := make(map[string]any, len(newList))
+	for i, key := range newKeys {
+		newByKey[key] = newList[i]
+		changes = compareValues(path+"["+key+"]", oldByKey[key], newList[i], changes)
+	}
+	for i, key := range oldKeys {
+		if _, ok := newByKey[key]; !ok {
+			changes = compareValues(path+"["+key+"]", oldList[i], nil, changes)
+		}
+	}
+	return changes, true
+}
+
+// alignLists reorders keyed lists in clusterVal to the order of the same elements in fileVal,
+// so rendered diff shows changes of matching elements instead of the reordering. Elements missing in file go last.
+func alignLists(fileVal, clusterVal any) {
+	fileMap, ok := fileVal.(map[string]any)
+	if !ok {
+		return
+	}
+	clusterMap, ok := clusterVal.(map[string]any)
+	if !ok {
+		return
+	}
+	for k, cv := range clusterMap {
+		fv, ok := fileMap[k]
+		if !ok {
+			continue
+		}
+		clusterList, ok := cv.([]any)
+		if !ok {
+			alignLists(fv, cv)
+			continue
+		}
+		fileList, ok := fv.([]any)
+		if !ok {
+			continue
+		}
+
+		clusterKeys, keyed := filter.ListKeys(k, clusterList)
+		fileKeys, fileKeyed := filter.ListKeys(k, fileList)
+		if !keyed || !fileKeyed {
+			for i := range min(len(fileList), len(clusterList)) {
+				alignLists(fileList[i], clusterList[i])
+			}
+			continue
+		}
+		clusterByKey := make(map[string]any, len(clusterList))
+		for i, key := range clusterKeys {
+			clusterByKey[key] = clusterList[i]
+		}
+		aligned := make([]any, 0, len(clusterList))
+		for i, key := range fileKeys {
+			if item, ok := clusterByKey[key]; ok {
+				alignLists(fileList[i], item)
+				aligned = append(aligned, item)
+				delete(clusterByKey, key)
+			}
+		}
+		for i, key := range clusterKeys {
+			if _, ok := clusterByKey[key]; ok {
+				aligned = append(aligned, clusterList[i])
+			}
+		}
+		clusterMap[k] = aligned
+	}
+}
@@ -0,0 +1,57 @@
+package diff
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestAlignLists(t *testing.T) {
+	containers := func(items ...any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Pod",
+			"metadata":   map[string]any{"name": "pod"},
+			"spec":       map[string]any{"containers": items},
+		}}
+	}
+	fileObj := containers(
+		map[string]any{"name": "app", "image": "app:2"},
+		map[string]any{"name": "worker", "image": "worker:1"},
+	)
+	clusterObj := containers(
+		map[string]any{"name": "sidecar", "image": "proxy:1"},
+		map[string]any{"name": "worker", "image": "worker:1"},
+		map[string]any{"name": "app", "image": "app:1"},
+	)
+
+	res, err := HasDiff(fileObj, clusterObj)
+	if err != nil {
+		t.Fatalf("HasDiff() unexpected error = %v", err)
+	}
+	expectedChanges := []Change{
+		{Path: "spec.containers[name=app].image", Old: "app:1", New: "app:2"},
+		{Path: "spec.containers[name=sidecar]", Old: map[string]any{"name": "sidecar", "image": "proxy:1"}},
+	}
+	if !reflect.DeepEqual(res.Changes, expectedChanges) {
+		t.Errorf("HasDiff() changes = %v, expected %v", res.Changes, expectedChanges)
+	}
+	expectedDiff := `--- cluster/Pod-pod.yaml
++++ file/Pod-pod.yaml
+@@ -4,9 +4,7 @@
+   name: pod
+ spec:
+   containers:
+-  - image: app:1
++  - image: app:2
+     name: app
+   - image: worker:1
+     name: worker
+-  - image: proxy:1
+-    name: sidecar
+`
+	if res.Diff != expectedDiff {
+		t.Errorf("HasDiff() diff =\n%s\nexpected\n%s", res.Diff, expectedDiff)
+	}
+}
@@ -0,0 +1,218 @@
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/openapi"
+)
+
+// openAPIDoc is OpenAPI v3 document of one group version, used to apply schema defaults
+type openAPIDoc struct {
+	schemas map[string]any
+	byGVK   map[schema.GroupVersionKind]string // schema name of the kind
+}
+
+// openAPIDefaults applies defaults from the cluster OpenAPI v3 schema to the object, like api server does for missing fields.
+// Documents are fetched once per group version, errors are reported once and the object is left as is.
+func (c *clusterSource) openAPIDefaults(obj *unstructured.Unstructured) error {
+	gvk := obj.GroupVersionKind()
+	doc, err := c.openAPIDoc(gvk.GroupVersion())
+	if err != nil || doc == nil {
+		return err
+	}
+	name, ok := doc.byGVK[gvk]
+	if !ok {
+		return nil
+	}
+	doc.applyDefaults(doc.schemas[name], obj.Object)
+	return nil
+}
+
+// openAPIEntry is OpenAPI document of a group version, fetched once
+type openAPIEntry struct {
+	once sync.Once
+	doc  *openAPIDoc // nil for missing or failed group version
+}
+
+// openAPIDoc returns the document of the group version, fetching it once. Lock only guards the map of entries,
+// so fetching different group versions does not block each other. Error is returned only to the call which fetched it.
+func (c *clusterSource) openAPIDoc(gv schema.GroupVersion) (*openAPIDoc, error) {
+	c.openAPIMu.Lock()
+	if c.openAPIDocs == nil {
+		c.openAPIDocs = make(map[schema.GroupVersion]*openAPIEntry)
+	}
+	entry, ok := c.openAPIDocs[gv]
+	if !ok {
+		entry = &openAPIEntry{}
+		c.openAPIDocs[gv] = entry
+	}
+	c.openAPIMu.Unlock()
+
+	var err error
+	entry.once.Do(func() {
+		entry.doc, err = c.fetchOpenAPIDoc(gv)
+	})
+	return entry.doc, err
+}
+
+// getOpenAPIPaths returns OpenAPI v3 paths of the cluster, fetched once
+func (c *clusterSource) getOpenAPIPaths() (map[string]openapi.GroupVersion, error) {
+	c.openAPIPathsOnce.Do(func() {
+		c.openAPIPaths, c.openAPIPathsErr = c.discovery.OpenAPIV3().Paths()
+	})
+	return c.openAPIPaths, c.openAPIPathsErr
+}
+
+func (c *clusterSource) fetchOpenAPIDoc(gv schema.GroupVersion) (*openAPIDoc, error) {
+	paths, err := c.getOpenAPIPaths()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get openapi paths: %w", err)
+	}
+	path := "apis/" + gv.String()
+	if gv.Group == "" {
+		path = "api/" + gv.Version
+	}
+	p, ok := paths[path]
+	if !ok {
+		return nil, nil
+	}
+	data, err := p.Schema(runtime.ContentTypeJSON)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get openapi schema for %s: %w", gv.String(), err)
+	}
+	doc, err := parseOpenAPIDoc(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse openapi schema for %s: %w", gv.String(), err)
+	}
+	return doc, nil
+}
+
+// parseOpenAPIDoc indexes schemas of the document by x-kubernetes-group-version-kind
+func parseOpenAPIDoc(data []byte) (*openAPIDoc, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var raw struct {
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := dec.Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	doc := &openAPIDoc{
+		schemas: raw.Components.Schemas,
+		byGVK:   make(map[schema.GroupVersionKind]string),
+	}
+	for name, s := range doc.schemas {
+		sm, _ := s.(map[string]any)
+		gvks, _ := sm["x-kubernetes-group-version-kind"].([]any)
+		for _, g := range gvks {
+			gm, _ := g.(map[string]any)
+			group, _ := gm["group"].(string)
+			version, _ := gm["version"].(string)
+			kind, _ := gm["kind"].(string)
+			doc.byGVK[schema.GroupVersionKind{Group: group, Version: version, Kind: kind}] = name
+		}
+	}
+	return doc, nil
+}
+
+// resolve follows $ref and single allOf wrappers, which k8s uses for fields with description or default
+func (doc *openAPIDoc) resolve(s any) map[string]any {
+	for range 10 { // guard against ref loops
+		sm, ok := s.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if ref, ok := sm["$ref"].(string); ok {
+			s = doc.schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+			continue
+		}
+		if allOf, ok := sm["allOf"].([]any); ok && len(allOf) == 1 && sm["properties"] == nil {
+			s = allOf[0]
+			continue
+		}
+		return sm
+	}
+	return nil
+}
+
+// applyDefaults sets default values of missing fields recursively, for the fields present in value
+func (doc *openAPIDoc) applyDefaults(s any, value any) {
+	sm := doc.resolve(s)
+	if sm == nil {
+		return
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := sm["properties"].(map[string]any)
+		for name, ps := range props {
+			pv, ok := v[name]
+			if !ok {
+				d := schemaDefault(ps)
+				if d == nil {
+					continue
+				}
+				pv = jsonToUnstructured(d)
+				v[name] = pv
+			}
+			doc.applyDefaults(ps, pv)
+		}
+		if ap, ok := sm["additionalProperties"].(map[string]any); ok {
+			for name, pv := range v {
+				if _, ok := props[name]; !ok {
+					doc.applyDefaults(ap, pv)
+				}
+			}
+		}
+	case []any:
+		for _, item := range v {
+			doc.applyDefaults(sm["items"], item)
+		}
+	}
+}
+
+// schemaDefault returns default of the property, set either on the property itself or on the referenced schema
+func schemaDefault(s any) any {
+	sm, _ := s.(map[string]any)
+	if d, ok := sm["default"]; ok {
+		return d
+	}
+	if allOf, ok := sm["allOf"].([]any); ok && len(allOf) == 1 {
+		return schemaDefault(allOf[0])
+	}
+	return nil
+}
+
+// jsonToUnstructured deep copies decoded json value, converting numbers to int64 or float64 like in unstructured objects
+func jsonToUnstructured(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, val := range v {
+			res[k] = jsonToUnstructured(val)
+		}
+		return res
+	case []any:
+		res := make([]any, len(v))
+		for i, val := range v {
+			res[i] = jsonToUnstructured(val)
+		}
+		return res
+	}
+	return v
+}
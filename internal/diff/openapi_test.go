@@ -0,0 +1,152 @@
+package diff
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/openapi"
+	"k8s.io/client-go/openapi/openapitest"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+// widgetOpenAPI is OpenAPI v3 document of example.io/v1 group version
+const widgetOpenAPI = `{"components": {"schemas": {
+	  "io.example.v1.Widget": {
+	    "type": "object",
+	    "x-kubernetes-group-version-kind": [{"group": "example.io", "version": "v1", "kind": "Widget"}],
+	    "properties": {
+	      "apiVersion": {"type": "string"},
+	      "spec": {"allOf": [{"$ref": "#/components/schemas/io.example.v1.WidgetSpec"}], "default": {}}
+	    }
+	  },
+	  "io.example.v1.WidgetSpec": {
+	    "type": "object",
+	    "properties": {
+	      "replicas": {"type": "integer", "default": 1},
+	      "ratio": {"type": "number", "default": 0.5},
+	      "mode": {"type": "string", "default": "auto"},
+	      "ports": {"type": "array", "items": {"$ref": "#/components/schemas/io.example.v1.Port"}},
+	      "labels": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/io.example.v1.Port"}}
+	    }
+	  },
+	  "io.example.v1.Port": {
+	    "type": "object",
+	    "properties": {"port": {"type": "integer"}, "protocol": {"type": "string", "default": "TCP"}}
+	  }
+	}}}`
+
+func TestOpenAPIDefaults(t *testing.T) {
+	doc, err := parseOpenAPIDoc([]byte(widgetOpenAPI))
+	if err != nil {
+		t.Fatalf("parseOpenAPIDoc() unexpected error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     any
+		expected any
+	}{
+		{
+			name:     "missing spec gets default",
+			spec:     nil,
+			expected: map[string]any{"replicas": int64(1), "ratio": 0.5, "mode": "auto"},
+		},
+		{
+			name: "set values are kept, nested defaults applied",
+			spec: map[string]any{"replicas": int64(3), "ports": []any{map[string]any{"port": int64(80)}},
+				"labels": map[string]any{"a": map[string]any{"port": int64(1)}}},
+			expected: map[string]any{"replicas": int64(3), "ratio": 0.5, "mode": "auto",
+				"ports":  []any{map[string]any{"port": int64(80), "protocol": "TCP"}},
+				"labels": map[string]any{"a": map[string]any{"port": int64(1), "protocol": "TCP"}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: map[string]any{"apiVersion": "example.io/v1", "kind": "Widget"}}
+			if tt.spec != nil {
+				obj.Object["spec"] = tt.spec
+			}
+			name, ok := doc.byGVK[obj.GroupVersionKind()]
+			if !ok {
+				t.Fatalf("schema for %v not found", obj.GroupVersionKind())
+			}
+			doc.applyDefaults(doc.schemas[name], obj.Object)
+			if !reflect.DeepEqual(obj.Object["spec"], tt.expected) {
+				t.Errorf("applyDefaults() spec = %v, expected %v", obj.Object["spec"], tt.expected)
+			}
+		})
+	}
+}
+
+// openAPIDiscovery is fake discovery with OpenAPI v3 client, which counts Paths calls
+type openAPIDiscovery struct {
+	*fakediscovery.FakeDiscovery
+	client *countingOpenAPIClient
+}
+
+func (d openAPIDiscovery) OpenAPIV3() openapi.Client {
+	return d.client
+}
+
+type countingOpenAPIClient struct {
+	openapitest.FakeClient
+	calls atomic.Int32
+}
+
+func (c *countingOpenAPIClient) Paths() (map[string]openapi.GroupVersion, error) {
+	c.calls.Add(1)
+	return c.FakeClient.Paths()
+}
+
+func TestApplyFilterOpenAPIDefaults(t *testing.T) {
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &countingOpenAPIClient{FakeClient: openapitest.FakeClient{PathsMap: map[string]openapi.GroupVersion{
+		"apis/example.io/v1": openapitest.FakeGroupVersion{GVSpec: []byte(widgetOpenAPI)},
+		"apis/broken.io/v1":  openapitest.FakeGroupVersion{ForcedErr: errors.New("unavailable")},
+	}}}
+	cluster := newClusterSource(nil, openAPIDiscovery{FakeDiscovery: &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{}}, client: client})
+	d := &Diff{OpenAPIDefaults: true, Filter: f, cluster: cluster, source: cluster}
+
+	tests := []struct {
+		name       string
+		apiVersion string
+		expected   any
+	}{
+		{name: "defaults from schema", apiVersion: "example.io/v1", expected: map[string]any{"replicas": int64(1), "ratio": 0.5, "mode": "auto"}},
+		{name: "group version missing in paths", apiVersion: "other.io/v1"},
+		{name: "failed schema", apiVersion: "broken.io/v1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			objs := make([]*unstructured.Unstructured, 5)
+			for i := range objs {
+				objs[i] = &unstructured.Unstructured{Object: map[string]any{"apiVersion": tt.apiVersion, "kind": "Widget", "metadata": map[string]any{"name": "w"}}}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					d.applyFilter(objs[i], &unstructured.Unstructured{Object: map[string]any{}})
+				}()
+			}
+			wg.Wait()
+			for _, obj := range objs {
+				if !reflect.DeepEqual(obj.Object["spec"], tt.expected) {
+					t.Errorf("applyFilter() spec = %v, expected %v", obj.Object["spec"], tt.expected)
+				}
+			}
+		})
+	}
+	if calls := client.calls.Load(); calls != 1 {
+		t.Errorf("Paths() called %d times, expected 1", calls)
+	}
+}
@@ -0,0 +1,78 @@
+package diff
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// cacheKey identifies prefetched object, empty name means the whole resource in namespace has been listed
+type cacheKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+	name      string
+}
+
+// prefetch lists objects of the same resource and namespace in one request, to serve cluster lookups from memory.
+// Groups which could not be listed (e.g. only `get` is permitted) fall back to per-object GET.
+func (d *Diff) prefetch(items []item) {
+	d.cluster.prefetched = make(map[cacheKey]*unstructured.Unstructured)
+	var groups []cacheKey
+	namespaced := make(map[cacheKey]bool)
+	for _, it := range items {
+		gvk := it.obj.GroupVersionKind()
+		if gvk.Kind == "Secret" && gvk.Group == "" && d.SkipSecrets {
+			continue
+		}
+		gvr, isNamespaced, err := d.cluster.getGVRAndScope(gvk)
+		if err != nil {
+			continue // would be reported on diff
+		}
+		namespace := d.resolveNamespace(it.obj, isNamespaced)
+		if isNamespaced && namespace == "" {
+			continue
+		}
+		if !isNamespaced {
+			namespace = ""
+		}
+		key := cacheKey{gvr: *gvr, namespace: namespace}
+		if _, ok := namespaced[key]; !ok {
+			groups = append(groups, key)
+			namespaced[key] = isNamespaced
+		}
+	}
+
+	for _, key := range groups {
+		objs, err := d.cluster.listObjects(key.gvr, namespaced[key], key.namespace, "")
+		if err != nil {
+			if errors.IsForbidden(err) {
+				fmt.Fprintf(os.Stderr, "Warning: no permission to list %s, fetching objects one by one\n", key.gvr.Resource)
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: failed to list %s: %v, fetching objects one by one\n", key.gvr.Resource, err)
+			}
+			continue
+		}
+		d.cluster.prefetched[key] = nil
+		for i := range objs {
+			d.cluster.prefetched[cacheKey{gvr: key.gvr, namespace: key.namespace, name: objs[i].GetName()}] = &objs[i]
+		}
+	}
+}
+
+// fromPrefetch returns a copy of prefetched object, or empty object if it is missing in the listed resource.
+// Returns false if the resource has not been listed.
+func (c *clusterSource) fromPrefetch(gvr schema.GroupVersionResource, isNamespaced bool, namespace, name string) (*unstructured.Unstructured, bool) {
+	if !isNamespaced {
+		namespace = ""
+	}
+	if _, ok := c.prefetched[cacheKey{gvr: gvr, namespace: namespace}]; !ok {
+		return nil, false
+	}
+	if obj, ok := c.prefetched[cacheKey{gvr: gvr, namespace: namespace, name: name}]; ok {
+		return obj.DeepCopy(), true
+	}
+	return &unstructured.Unstructured{}, true
+}
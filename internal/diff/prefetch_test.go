@@ -0,0 +1,46 @@
+package diff
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestPrefetch(t *testing.T) {
+	items := []item{{obj: configMap("cm0", nil, nil), source: "a.yml"}, {obj: configMap("cm1", nil, nil), source: "a.yml"}}
+
+	tests := []struct {
+		name      string
+		forbidden bool
+		listed    bool
+	}{
+		{name: "list allowed", listed: true},
+		{name: "list forbidden", forbidden: true, listed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, dynamicClient := newFakeCluster([]fakeResource{configMaps}, configMap("cm0", nil, nil))
+			if tt.forbidden {
+				dynamicClient.PrependReactor("list", "configmaps", func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.NewForbidden(configMaps.gvr.GroupResource(), "", nil)
+				})
+			}
+			d := &Diff{cluster: cluster}
+			d.prefetch(items)
+
+			obj, ok := d.cluster.fromPrefetch(configMaps.gvr, true, "default", "cm0")
+			if ok != tt.listed {
+				t.Fatalf("fromPrefetch() listed = %v, expected %v", ok, tt.listed)
+			}
+			if ok && obj.GetName() != "cm0" {
+				t.Errorf("fromPrefetch() expected cm0 to be found, got %v", obj)
+			}
+			obj, ok = d.cluster.fromPrefetch(configMaps.gvr, true, "default", "cm1")
+			if ok && len(obj.Object) != 0 {
+				t.Errorf("fromPrefetch() expected cm1 to be missing, got %v", obj)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package diff
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+	kyaml "sigs.k8s.io/yaml"
+)
+
+// objectKey identifies object in the cluster regardless of api version
+type objectKey struct {
+	resource  schema.GroupResource
+	namespace string
+	name      string
+}
+
+// pruneScope is a resource type and namespace to look for objects missing in files
+type pruneScope struct {
+	gvr        schema.GroupVersionResource
+	gvk        schema.GroupVersionKind
+	namespaced bool
+	namespace  string
+}
+
+// markApplied remembers object found in files, and its resource type to check for pruning
+func (d *Diff) markApplied(gvr schema.GroupVersionResource, gvk schema.GroupVersionKind, isNamespaced bool, namespace, name string) {
+	if !isNamespaced {
+		namespace = ""
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.applied[objectKey{gvr.GroupResource(), namespace, name}] = true
+	for _, s := range d.pruneScopes {
+		if s.gvr.GroupResource() == gvr.GroupResource() && s.namespace == namespace {
+			return
+		}
+	}
+	d.pruneScopes = append(d.pruneScopes, pruneScope{gvr: gvr, gvk: gvk, namespaced: isNamespaced, namespace: namespace})
+}
+
+// prune lists cluster objects matching selector for resource types found in files,
+// and reports the ones missing in files as deleted. Returns true if any found.
+// Secrets are listed via metadata-only API with --secrets-metadata, as the values can't be read then.
+func (d *Diff) prune() (bool, error) {
+	hasDiff := false
+	for _, scope := range d.pruneScopes {
+		var objs []unstructured.Unstructured
+		var err error
+		if d.SecretsMetadata && scope.gvr.GroupResource() == secretsGVR.GroupResource() {
+			objs, err = d.cluster.listSecretObjects(scope.namespace, d.Selector)
+			if errors.IsForbidden(err) {
+				fmt.Fprintf(os.Stderr, "Warning: listing Secrets metadata is forbidden in namespace %s, skipping prune of Secrets\n", scope.namespace)
+				continue
+			}
+		} else {
+			objs, err = d.cluster.listObjects(scope.gvr, scope.namespaced, scope.namespace, d.Selector)
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to list %s: %w", scope.gvr.String(), err)
+		}
+		for _, obj := range objs {
+			if d.applied[objectKey{scope.gvr.GroupResource(), scope.namespace, obj.GetName()}] {
+				continue
+			}
+			if ref := metav1.GetControllerOf(&obj); ref != nil {
+				continue // managed by another object, not by manifests
+			}
+			obj.SetAPIVersion(scope.gvk.GroupVersion().String())
+			obj.SetKind(scope.gvk.Kind)
+			res, err := deletedResult(&obj, d.Filter, clusterLabels, nil)
+			if err != nil {
+				return false, err
+			}
+			res.Namespace = scope.namespace
+			if err := d.report(os.Stdout, res); err != nil {
+				return false, fmt.Errorf("failed to write report: %w", err)
+			}
+			hasDiff = true
+		}
+	}
+	return hasDiff, nil
+}
+
+// listObjects returns all objects of the resource in namespace matching selector, sorted by name
+func (c *clusterSource) listObjects(gvr schema.GroupVersionResource, isNamespaced bool, namespace, selector string) ([]unstructured.Unstructured, error) {
+	resourceInterface := c.resourceInterface(gvr, isNamespaced, namespace)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(120*time.Second))
+	defer cancel()
+	var res []unstructured.Unstructured
+	opts := metav1.ListOptions{LabelSelector: selector, Limit: 500}
+	for {
+		var list *unstructured.UnstructuredList
+		err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+			if isRetriableError(err) {
+				fmt.Fprintf(os.Stderr, "List resource %s error: %v, will retry...\n", gvr.Resource, err)
+				return true
+			}
+			return false
+		}, func() error {
+			var err error
+			list, err = resourceInterface.List(ctx, opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, list.Items...)
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			break
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].GetName() < res[j].GetName() })
+	return res, nil
+}
+
+// deletedResult renders diff for the cluster object which would be deleted, values at decrypted paths are masked
+func deletedResult(clusterObj *unstructured.Unstructured, f *filter.Filter, labels [2]string, decrypted [][]string) (*Result, error) {
+	res := newResult(clusterObj, StatusDeleted)
+	fileObj := &unstructured.Unstructured{Object: map[string]any{}}
+	f.Apply(fileObj, clusterObj)
+	maskSecret(fileObj, clusterObj)
+	maskDecrypted(fileObj, clusterObj, decrypted)
+	clusterYAML, err := kyaml.Marshal(clusterObj.Object)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal cluster object: %w", err)
+	}
+	if res.Diff, _, err = renderDiff(clusterObj, labels, nil, clusterYAML); err != nil {
+		return nil, err
+	}
+	res.Changes = compareValues("", map[string]any(clusterObj.Object), map[string]any{}, nil)
+	return res, nil
+}
@@ -0,0 +1,94 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestPrune(t *testing.T) {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+	cluster, _ := newFakeCluster([]fakeResource{configMaps},
+		configMap("applied", nil, map[string]any{"app": "test"}),
+		configMap("orphan", nil, map[string]any{"app": "test"}),
+		configMap("other", nil, map[string]any{"app": "other"}),
+	)
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Diff{Selector: "app=test", Output: "json", Filter: f, applied: map[objectKey]bool{}, cluster: cluster}
+	d.markApplied(configMaps.gvr, gvk, true, "default", "applied")
+	hasDiff, err := d.prune()
+	if err != nil {
+		t.Fatalf("prune() unexpected error = %v", err)
+	}
+	if !hasDiff {
+		t.Errorf("prune() expected orphan object to be found")
+	}
+
+	d.markApplied(configMaps.gvr, gvk, true, "default", "orphan")
+	if hasDiff, _ = d.prune(); hasDiff {
+		t.Errorf("prune() expected no objects to be found")
+	}
+}
+
+func TestPruneSecretsMetadata(t *testing.T) {
+	gvk := schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	scheme := fakemetadata.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	secret := func(name string) *metav1.PartialObjectMetadata {
+		return &metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "test"}},
+		}
+	}
+
+	tests := []struct {
+		name              string
+		metadataForbidden bool
+		hasDiff           bool
+	}{
+		{name: "orphan found via metadata", hasDiff: true},
+		{name: "metadata list forbidden", metadataForbidden: true, hasDiff: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cluster, dynamicClient := newFakeCluster([]fakeResource{secrets})
+			dynamicClient.PrependReactor("list", "secrets", func(clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.NewForbidden(secretsGVR.GroupResource(), "", nil)
+			})
+			metadataClient := fakemetadata.NewSimpleMetadataClient(scheme, secret("applied"), secret("orphan"))
+			if tt.metadataForbidden {
+				metadataClient.PrependReactor("list", "secrets", func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.NewForbidden(secretsGVR.GroupResource(), "", nil)
+				})
+			}
+			cluster.metadata = metadataClient
+
+			d := &Diff{SecretsMetadata: true, Selector: "app=test", Output: "json", Filter: f, applied: map[objectKey]bool{}, cluster: cluster}
+			d.markApplied(secretsGVR, gvk, true, "default", "applied")
+			hasDiff, err := d.prune()
+			if err != nil {
+				t.Fatalf("prune() unexpected error = %v", err)
+			}
+			if hasDiff != tt.hasDiff {
+				t.Errorf("prune() diff = %v, expected %v", hasDiff, tt.hasDiff)
+			}
+		})
+	}
+}
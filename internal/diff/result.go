@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type Status string
+
+const (
+	StatusUnchanged Status = "unchanged"
+	StatusChanged   Status = "changed"
+	StatusNew       Status = "new"
+	StatusDeleted   Status = "deleted"
+	StatusSkipped   Status = "skipped"
+	StatusError     Status = "error"
+)
+
+// Change is a single field difference between cluster (Old) and file (New) objects
+type Change struct {
+	Path string `json:"path"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// Result of comparing one object
+type Result struct {
+	APIVersion string   `json:"apiVersion"`
+	Kind       string   `json:"kind"`
+	Namespace  string   `json:"namespace,omitempty"`
+	Name       string   `json:"name"`
+	Source     string   `json:"source,omitempty"`
+	Status     Status   `json:"status"`
+	Changes    []Change `json:"changes,omitempty"`
+	Rotated    []string `json:"rotated,omitempty"` // SealedSecret keys with changed ciphertext
+	Error      string   `json:"error,omitempty"`
+	Diff       string   `json:"-"` // rendered diff output
+}
+
+// HasDiff returns true if the object is changed, new or deleted
+func (r *Result) HasDiff() bool {
+	return r.Status == StatusChanged || r.Status == StatusNew || r.Status == StatusDeleted
+}
+
+// report writes the result in the requested output format
+func (d *Diff) report(w io.Writer, res *Result) error {
+	if d.Output == "json" {
+		return json.NewEncoder(w).Encode(res)
+	}
+	_, err := fmt.Fprint(w, res.Diff)
+	return err
+}
+
+// compareValues collects field differences between old and new values recursively
+func compareValues(path string, oldVal, newVal any, changes []Change) []Change {
+	oldMap, oldIsMap := oldVal.(map[string]any)
+	newMap, newIsMap := newVal.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := make([]string, 0, len(oldMap)+len(newMap))
+		for k := range oldMap {
+			keys = append(keys, k)
+		}
+		for k := range newMap {
+			if _, ok := oldMap[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			oldList, oldIsList := oldMap[k].([]any)
+			newList, newIsList := newMap[k].([]any)
+			if oldIsList && newIsList {
+				var keyed bool
+				if changes, keyed = compareKeyedList(joinPath(path, k), k, oldList, newList, changes); keyed {
+					continue
+				}
+			}
+			changes = compareValues(joinPath(path, k), oldMap[k], newMap[k], changes)
+		}
+		return changes
+	}
+
+	oldList, oldIsList := oldVal.([]any)
+	newList, newIsList := newVal.([]any)
+	if oldIsList && newIsList {
+		for i := 0; i < max(len(oldList), len(newList)); i++ {
+			var o, n any
+			if i < len(oldList) {
+				o = oldList[i]
+			}
+			if i < len(newList) {
+				n = newList[i]
+			}
+			changes = compareValues(path+"["+strconv.Itoa(i)+"]", o, n, changes)
+		}
+		return changes
+	}
+
+	if !reflect.DeepEqual(oldVal, newVal) {
+		changes = append(changes, Change{Path: path, Old: oldVal, New: newVal})
+	}
+	return changes
+}
+
+// joinPath appends a map key to the field path, quoting keys which are not plain identifiers
+func joinPath(path, key string) string {
+	if strings.ContainsAny(key, ".[]'") {
+		return path + "['" + key + "']"
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
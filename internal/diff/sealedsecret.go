@@ -0,0 +1,131 @@
+package diff
+
+import (
+	stderrors "errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var secretGVK = schema.GroupVersionKind{Version: "v1", Kind: "Secret"}
+
+// errNoSnapshotSecrets means the snapshot has no Secrets to compare SealedSecrets with
+var errNoSnapshotSecrets = stderrors.New("secrets are not saved in the snapshot")
+
+func isSealedSecret(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == "bitnami.com" && gvk.Kind == "SealedSecret"
+}
+
+// diffSealedSecret compares Secret which would be unsealed from the file with the live Secret, by metadata and key names,
+// as ciphertext is different on each sealing. Keys with ciphertext changed from clusterObj are reported as rotated.
+func (d *Diff) diffSealedSecret(fileObj, clusterObj *unstructured.Unstructured, namespace string) (*Result, error) {
+	liveSecret, hasKeys, err := d.getLiveSecret(clusterObj, namespace, fileObj.GetName())
+	if err == errNoSnapshotSecrets {
+		fmt.Fprintf(os.Stderr, "Warning: Secrets are not saved in the snapshot, skipping SealedSecret %s\n", fileObj.GetName())
+		res := newResult(fileObj, StatusSkipped)
+		res.Namespace = namespace
+		res.Error = err.Error()
+		return res, nil
+	}
+	if err != nil {
+		res := newResult(fileObj, StatusError)
+		res.Namespace = namespace
+		return res, fmt.Errorf("failed to get secret from cluster: %w", err)
+	}
+	fileSecret := secretMetadata(sealedSecretTemplate(fileObj), hasKeys || len(liveSecret.Object) == 0)
+	if len(liveSecret.Object) != 0 {
+		liveSecret = secretMetadata(liveSecret, hasKeys)
+	}
+
+	d.Filter.Apply(fileSecret, liveSecret)
+	res, err := HasDiff(fileSecret, liveSecret)
+	if err != nil {
+		return res, err
+	}
+	res.APIVersion = fileObj.GetAPIVersion()
+	res.Kind = fileObj.GetKind()
+	res.Namespace = namespace
+	res.Rotated = rotatedKeys(fileObj, clusterObj)
+	for _, k := range res.Rotated {
+		res.Diff += fmt.Sprintf("# %s/%s: ciphertext of key %q rotated\n", fileObj.GetKind(), fileObj.GetName(), k)
+	}
+	return res, nil
+}
+
+// getLiveSecret returns Secret unsealed from the SealedSecret, and true if its key names are known.
+// When Secrets can't be read, the Secret is built from the live SealedSecret instead.
+func (d *Diff) getLiveSecret(sealedObj *unstructured.Unstructured, namespace, name string) (*unstructured.Unstructured, bool, error) {
+	fromSealed := func() (*unstructured.Unstructured, bool, error) {
+		if len(sealedObj.Object) == 0 {
+			return sealedObj, false, nil
+		}
+		return sealedSecretTemplate(sealedObj), true, nil
+	}
+	if d.SkipSecrets {
+		return fromSealed()
+	}
+	if d.SecretsMetadata && d.cluster != nil {
+		obj, hasKeys, err := d.cluster.getSecretMetadata(namespace, name)
+		if errors.IsForbidden(err) {
+			fmt.Fprintf(os.Stderr, "Warning: listing Secrets metadata is forbidden in namespace %s, using SealedSecret %s instead\n", namespace, name)
+			return fromSealed()
+		}
+		return obj, hasKeys, err
+	}
+
+	gvr, isNamespaced, err := d.source.getGVRAndScope(secretGVK)
+	if err != nil && d.cluster == nil {
+		return nil, false, errNoSnapshotSecrets // comparing SealedSecret with itself would hide the drift
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not find GVR for %s: %v\n", secretGVK.String(), err)
+		return fromSealed()
+	}
+	obj, err := d.source.getObject(*gvr, isNamespaced, namespace, name)
+	return obj, true, err
+}
+
+// sealedSecretTemplate returns Secret which the controller creates from the SealedSecret, with encrypted values as is
+func sealedSecretTemplate(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	template, _, _ := unstructured.NestedMap(obj.Object, "spec", "template")
+	res := &unstructured.Unstructured{Object: template}
+	if res.Object == nil {
+		res.Object = map[string]any{}
+	}
+	res.SetAPIVersion(secretGVK.GroupVersion().String())
+	res.SetKind(secretGVK.Kind)
+	res.SetName(obj.GetName())
+	res.SetNamespace(obj.GetNamespace())
+
+	encrypted, _, _ := unstructured.NestedMap(obj.Object, "spec", "encryptedData")
+	if len(encrypted) > 0 {
+		data, _ := res.Object["data"].(map[string]any)
+		if data == nil {
+			data = make(map[string]any, len(encrypted))
+			res.Object["data"] = data
+		}
+		for k, v := range encrypted {
+			data[k] = v
+		}
+	}
+	return res
+}
+
+// rotatedKeys returns sorted keys of encryptedData which differ between the objects
+func rotatedKeys(fileObj, clusterObj *unstructured.Unstructured) []string {
+	fileData, _, _ := unstructured.NestedMap(fileObj.Object, "spec", "encryptedData")
+	clusterData, _, _ := unstructured.NestedMap(clusterObj.Object, "spec", "encryptedData")
+	var res []string
+	for k, v := range fileData {
+		if cv, ok := clusterData[k]; ok && cv != v {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
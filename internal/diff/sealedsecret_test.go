@@ -0,0 +1,70 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSealedSecret(t *testing.T) {
+	sealed := func(labels map[string]any, encrypted map[string]any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "bitnami.com/v1alpha1",
+			"kind":       "SealedSecret",
+			"metadata":   map[string]any{"name": "s", "namespace": "default"},
+			"spec": map[string]any{
+				"encryptedData": encrypted,
+				"template":      map[string]any{"metadata": map[string]any{"labels": labels}, "type": "Opaque"},
+			},
+		}}
+	}
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]any{"name": "s", "namespace": "default", "labels": map[string]any{"app": "a"}},
+		"type":       "Opaque",
+		"data":       map[string]any{"a": "dmFsdWU=", "b": "dmFsdWU="},
+	}}
+	sealedSecrets := fakeResource{gvr: schema.GroupVersionResource{Group: "bitnami.com", Version: "v1alpha1", Resource: "sealedsecrets"}, kind: "SealedSecret", namespaced: true}
+	cluster, _ := newFakeCluster([]fakeResource{secrets, sealedSecrets},
+		sealed(map[string]any{"app": "a"}, map[string]any{"a": "AgA1", "b": "AgB1"}), secret)
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		fileObj *unstructured.Unstructured
+		status  Status
+		rotated []string
+	}{
+		{name: "same", fileObj: sealed(map[string]any{"app": "a"}, map[string]any{"a": "AgA1", "b": "AgB1"}), status: StatusUnchanged},
+		{name: "resealed", fileObj: sealed(map[string]any{"app": "a"}, map[string]any{"a": "AgA2", "b": "AgB1"}), status: StatusUnchanged, rotated: []string{"a"}},
+		{name: "key removed", fileObj: sealed(map[string]any{"app": "a"}, map[string]any{"a": "AgA1"}), status: StatusChanged},
+		{name: "label changed", fileObj: sealed(map[string]any{"app": "b"}, map[string]any{"a": "AgA1", "b": "AgB1"}), status: StatusChanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Diff{Filter: f, cluster: cluster, source: cluster}
+			res, err := d.diffObject(tt.fileObj, nil)
+			if err != nil {
+				t.Fatalf("diffObject() unexpected error = %v", err)
+			}
+			if res.Status != tt.status {
+				t.Errorf("diffObject() status = %v, expected %v\n%s", res.Status, tt.status, res.Diff)
+			}
+			if !reflect.DeepEqual(res.Rotated, tt.rotated) {
+				t.Errorf("diffObject() rotated = %v, expected %v", res.Rotated, tt.rotated)
+			}
+			if strings.Contains(res.Diff, "AgA") || strings.Contains(res.Diff, "dmFsdWU=") {
+				t.Errorf("diffObject() diff contains secret values:\n%s", res.Diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,341 @@
+package diff
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	secretMask       = "***"
+	secretMaskBefore = "*** (before)"
+	secretMaskAfter  = "*** (after)"
+)
+
+func isSecret(obj *unstructured.Unstructured) bool {
+	gvk := obj.GroupVersionKind()
+	return gvk.Group == "" && gvk.Kind == "Secret"
+}
+
+// maskSecret replaces Secret values with markers like kubectl diff does, so they never get to the output.
+// Changed values are marked as before/after, so it is still visible which keys are changed, added or removed.
+func maskSecret(fileObj, clusterObj *unstructured.Unstructured) {
+	if !isSecret(fileObj) && !isSecret(clusterObj) {
+		return
+	}
+	mergeStringData(fileObj)
+	mergeStringData(clusterObj)
+
+	fileData, _ := fileObj.Object["data"].(map[string]any)
+	clusterData, _ := clusterObj.Object["data"].(map[string]any)
+	for k, v := range fileData {
+		cv, ok := clusterData[k]
+		if ok && !reflect.DeepEqual(cv, v) {
+			fileData[k] = secretMaskAfter
+			clusterData[k] = secretMaskBefore
+			continue
+		}
+		fileData[k] = secretMask
+		if ok {
+			clusterData[k] = secretMask
+		}
+	}
+	for k := range clusterData {
+		if _, ok := fileData[k]; !ok {
+			clusterData[k] = secretMask
+		}
+	}
+}
+
+// maskDecrypted replaces values at paths decrypted by sops with markers like maskSecret, for objects of any kind.
+// Paths are map keys only, so all the elements of lists on the way are masked.
+func maskDecrypted(fileObj, clusterObj *unstructured.Unstructured, paths [][]string) {
+	for _, path := range paths {
+		maskPath(fileObj.Object, clusterObj.Object, path)
+	}
+}
+
+// maskPath masks file and cluster values at path, nil value means it is missing
+func maskPath(fileVal, clusterVal any, path []string) (any, any) {
+	fileList, fileIsList := fileVal.([]any)
+	clusterList, clusterIsList := clusterVal.([]any)
+	if fileIsList || clusterIsList {
+		if !fileIsList && fileVal != nil {
+			fileVal = secretMask
+		}
+		if !clusterIsList && clusterVal != nil {
+			clusterVal = secretMask
+		}
+		for i := range max(len(fileList), len(clusterList)) {
+			var f, c any
+			if i < len(fileList) {
+				f = fileList[i]
+			}
+			if i < len(clusterList) {
+				c = clusterList[i]
+			}
+			f, c = maskPath(f, c, path)
+			if i < len(fileList) {
+				fileList[i] = f
+			}
+			if i < len(clusterList) {
+				clusterList[i] = c
+			}
+		}
+		return fileVal, clusterVal
+	}
+
+	if len(path) == 0 {
+		if fileVal != nil && clusterVal != nil && !reflect.DeepEqual(fileVal, clusterVal) {
+			return secretMaskAfter, secretMaskBefore
+		}
+		if fileVal != nil {
+			fileVal = secretMask
+		}
+		if clusterVal != nil {
+			clusterVal = secretMask
+		}
+		return fileVal, clusterVal
+	}
+
+	fileData, _ := fileVal.(map[string]any)
+	clusterData, _ := clusterVal.(map[string]any)
+	f, fileOk := fileData[path[0]]
+	c, clusterOk := clusterData[path[0]]
+	if !fileOk && !clusterOk {
+		return fileVal, clusterVal
+	}
+	f, c = maskPath(f, c, path[1:])
+	if fileOk {
+		fileData[path[0]] = f
+	}
+	if clusterOk {
+		clusterData[path[0]] = c
+	}
+	return fileVal, clusterVal
+}
+
+// mergeStringData moves stringData to base64 encoded data, same as the api server does on write
+func mergeStringData(obj *unstructured.Unstructured) {
+	stringData, ok := obj.Object["stringData"].(map[string]any)
+	if !ok {
+		return
+	}
+	delete(obj.Object, "stringData")
+	if len(stringData) == 0 {
+		return
+	}
+	data, ok := obj.Object["data"].(map[string]any)
+	if !ok {
+		data = make(map[string]any, len(stringData))
+		obj.Object["data"] = data
+	}
+	for k, v := range stringData {
+		if s, ok := v.(string); ok {
+			data[k] = base64.StdEncoding.EncodeToString([]byte(s))
+		} else {
+			data[k] = v // invalid, let it show in diff as is
+		}
+	}
+}
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// diffSecretMetadata compares only metadata, type and key names of the Secret, for the case when values can't be read.
+// Type and keys of the cluster Secret are taken from last-applied-configuration annotation, and skipped if it is missing.
+func (d *Diff) diffSecretMetadata(fileObj *unstructured.Unstructured, namespace string) (*Result, error) {
+	clusterObj, hasKeys, err := d.cluster.getSecretMetadata(namespace, fileObj.GetName())
+	if errors.IsForbidden(err) {
+		fmt.Fprintf(os.Stderr, "Warning: listing Secrets metadata is forbidden in namespace %s, skipping Secret %s\n", namespace, fileObj.GetName())
+		res := newResult(fileObj, StatusSkipped)
+		res.Namespace = namespace
+		res.Error = err.Error()
+		return res, nil
+	}
+	if err != nil {
+		res := newResult(fileObj, StatusError)
+		res.Namespace = namespace
+		return res, fmt.Errorf("failed to get secret metadata from cluster: %w", err)
+	}
+	fileObj = secretMetadata(fileObj, hasKeys || len(clusterObj.Object) == 0)
+
+	d.Filter.Apply(fileObj, clusterObj)
+	res, err := HasDiff(fileObj, clusterObj)
+	if res != nil {
+		res.Namespace = namespace
+	}
+	return res, err
+}
+
+// secretMetadata returns copy of the Secret with metadata only, and type with key names if keys is true
+func secretMetadata(obj *unstructured.Unstructured, keys bool) *unstructured.Unstructured {
+	metadata := map[string]any{"name": obj.GetName()}
+	if labels := obj.GetLabels(); len(labels) > 0 {
+		metadata["labels"] = toAnyMap(labels)
+	}
+	if annotations := obj.GetAnnotations(); len(annotations) > 0 {
+		metadata["annotations"] = toAnyMap(annotations)
+	}
+	res := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": obj.GetAPIVersion(),
+		"kind":       obj.GetKind(),
+		"metadata":   metadata,
+	}}
+	if !keys {
+		return res
+	}
+
+	obj = obj.DeepCopy()
+	mergeStringData(obj)
+	if t, ok := obj.Object["type"]; ok {
+		res.Object["type"] = t
+	}
+	if data, ok := obj.Object["data"].(map[string]any); ok {
+		names := make(map[string]any, len(data))
+		for k := range data {
+			names[k] = secretMask
+		}
+		res.Object["data"] = names
+	}
+	return res
+}
+
+func toAnyMap(m map[string]string) map[string]any {
+	res := make(map[string]any, len(m))
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
+
+// secretsList is metadata of all the Secrets in namespace, listed once
+type secretsList struct {
+	once  sync.Once
+	items map[string]metav1.PartialObjectMetadata
+	err   error
+}
+
+// getSecretMetadata returns Secret metadata via metadata-only API, or empty object if not found.
+// Returns true if type and key names are known from last-applied-configuration annotation.
+// All the Secrets in namespace are listed once and cached, concurrent calls for the same namespace wait for the list.
+func (c *clusterSource) getSecretMetadata(namespace, name string) (*unstructured.Unstructured, bool, error) {
+	secrets, err := c.secretsMetadata(namespace)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s, ok := secrets[name]
+	if !ok {
+		return &unstructured.Unstructured{}, false, nil
+	}
+	obj := &unstructured.Unstructured{Object: map[string]any{}}
+	obj.SetAPIVersion("v1")
+	obj.SetKind("Secret")
+	obj.SetName(s.Name)
+	obj.SetLabels(s.Labels)
+	obj.SetAnnotations(s.Annotations)
+
+	lastApplied, ok := s.Annotations[corev1.LastAppliedConfigAnnotation]
+	if !ok {
+		return secretMetadata(obj, false), false, nil
+	}
+	applied := &unstructured.Unstructured{}
+	if err := applied.UnmarshalJSON([]byte(lastApplied)); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to parse %s of Secret %s/%s: %v\n", corev1.LastAppliedConfigAnnotation, namespace, name, err)
+		return secretMetadata(obj, false), false, nil
+	}
+	res := secretMetadata(applied, true)
+	res.Object["metadata"] = secretMetadata(obj, false).Object["metadata"]
+	return res, true, nil
+}
+
+// secretsMetadata returns metadata of all the Secrets in namespace, listed once and cached.
+// Concurrent calls for the same namespace wait for the list.
+func (c *clusterSource) secretsMetadata(namespace string) (map[string]metav1.PartialObjectMetadata, error) {
+	c.secretsMu.Lock()
+	if c.secrets == nil {
+		c.secrets = make(map[string]*secretsList)
+	}
+	list, ok := c.secrets[namespace]
+	if !ok {
+		list = &secretsList{}
+		c.secrets[namespace] = list
+	}
+	c.secretsMu.Unlock()
+
+	list.once.Do(func() {
+		list.items, list.err = c.listSecretsMetadata(namespace)
+	})
+	return list.items, list.err
+}
+
+// listSecretObjects returns Secrets in namespace matching selector via metadata-only API, sorted by name.
+// Objects are the same as from getSecretMetadata, with owner references to skip managed Secrets on prune.
+func (c *clusterSource) listSecretObjects(namespace, selector string) ([]unstructured.Unstructured, error) {
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse selector: %w", err)
+	}
+	secrets, err := c.secretsMetadata(namespace)
+	if err != nil {
+		return nil, err
+	}
+	var res []unstructured.Unstructured
+	for name, s := range secrets {
+		if !sel.Matches(labels.Set(s.Labels)) {
+			continue
+		}
+		obj, _, err := c.getSecretMetadata(namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		obj.SetOwnerReferences(s.OwnerReferences)
+		res = append(res, *obj)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GetName() < res[j].GetName() })
+	return res, nil
+}
+
+// listSecretsMetadata lists metadata of all the Secrets in namespace, by pages
+func (c *clusterSource) listSecretsMetadata(namespace string) (map[string]metav1.PartialObjectMetadata, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(120*time.Second))
+	defer cancel()
+	secrets := make(map[string]metav1.PartialObjectMetadata)
+	opts := metav1.ListOptions{Limit: 500}
+	for {
+		var list *metav1.PartialObjectMetadataList
+		err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+			if isRetriableError(err) {
+				fmt.Fprintf(os.Stderr, "List secrets metadata error: %v, will retry...\n", err)
+				return true
+			}
+			return false
+		}, func() error {
+			var err error
+			list, err = c.metadata.Resource(secretsGVR).Namespace(namespace).List(ctx, opts)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range list.Items {
+			secrets[s.Name] = s
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			return secrets, nil
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package diff
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakemetadata "k8s.io/client-go/metadata/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+func TestMaskSecret(t *testing.T) {
+	secret := func(field string, data map[string]any) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]any{"name": "s"},
+		}}
+		if data != nil {
+			obj.Object[field] = data
+		}
+		return obj
+	}
+	tests := []struct {
+		name       string
+		fileObj    *unstructured.Unstructured
+		clusterObj *unstructured.Unstructured
+		status     Status
+		changes    []Change
+	}{
+		{
+			name:       "stringData matches data",
+			fileObj:    secret("stringData", map[string]any{"a": "value"}),
+			clusterObj: secret("data", map[string]any{"a": "dmFsdWU="}),
+			status:     StatusUnchanged,
+		},
+		{
+			name:       "changed, added and removed keys",
+			fileObj:    secret("data", map[string]any{"a": "bmV3", "b": "YWRkZWQ="}),
+			clusterObj: secret("data", map[string]any{"a": "b2xk", "c": "cmVtb3ZlZA=="}),
+			status:     StatusChanged,
+			changes: []Change{
+				{Path: "data.a", Old: secretMaskBefore, New: secretMaskAfter},
+				{Path: "data.b", New: secretMask},
+				{Path: "data.c", Old: secretMask},
+			},
+		},
+		{
+			name:       "invalid list values",
+			fileObj:    secret("stringData", map[string]any{"a": []any{"new"}}),
+			clusterObj: secret("data", map[string]any{"a": []any{"old"}}),
+			status:     StatusChanged,
+			changes:    []Change{{Path: "data.a", Old: secretMaskBefore, New: secretMaskAfter}},
+		},
+		{
+			name:       "new secret",
+			fileObj:    secret("data", map[string]any{"a": "dmFsdWU="}),
+			clusterObj: &unstructured.Unstructured{},
+			status:     StatusNew,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := HasDiff(tt.fileObj, tt.clusterObj)
+			if err != nil {
+				t.Fatalf("HasDiff() unexpected error = %v", err)
+			}
+			if res.Status != tt.status {
+				t.Errorf("HasDiff() status = %v, expected %v", res.Status, tt.status)
+			}
+			for _, secret := range []string{"value", "dmFsdWU=", "bmV3", "b2xk", "YWRkZWQ=", "cmVtb3ZlZA=="} {
+				if strings.Contains(res.Diff, secret) {
+					t.Errorf("HasDiff() diff contains secret value %q:\n%s", secret, res.Diff)
+				}
+			}
+			if tt.changes != nil && !reflect.DeepEqual(res.Changes, tt.changes) {
+				t.Errorf("HasDiff() changes = %v, expected %v", res.Changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestMaskDecrypted(t *testing.T) {
+	paths := [][]string{{"data", "password"}, {"spec", "env", "value"}}
+	env := func(values ...string) []any {
+		var res []any
+		for _, v := range values {
+			res = append(res, map[string]any{"name": "E", "value": v})
+		}
+		return res
+	}
+	tests := []struct {
+		name       string
+		fileObj    *unstructured.Unstructured
+		clusterObj *unstructured.Unstructured
+		status     Status
+		changes    []Change
+	}{
+		{
+			name:       "unchanged",
+			fileObj:    configMap("cm", map[string]any{"password": "topsecret"}, nil),
+			clusterObj: configMap("cm", map[string]any{"password": "topsecret"}, nil),
+			status:     StatusUnchanged,
+		},
+		{
+			name:       "changed value",
+			fileObj:    configMap("cm", map[string]any{"password": "newsecret", "plain": "a"}, nil),
+			clusterObj: configMap("cm", map[string]any{"password": "oldsecret", "plain": "b"}, nil),
+			status:     StatusChanged,
+			changes: []Change{
+				{Path: "data.password", Old: secretMaskBefore, New: secretMaskAfter},
+				{Path: "data.plain", Old: "b", New: "a"},
+			},
+		},
+		{
+			name:       "added list element",
+			fileObj:    &unstructured.Unstructured{Object: map[string]any{"kind": "Custom", "spec": map[string]any{"env": env("oldsecret", "newsecret")}}},
+			clusterObj: &unstructured.Unstructured{Object: map[string]any{"kind": "Custom", "spec": map[string]any{"env": env("oldsecret")}}},
+			status:     StatusChanged,
+			changes:    []Change{{Path: "spec.env[1]", New: map[string]any{"name": "E", "value": secretMask}}},
+		},
+		{
+			name:       "new object",
+			fileObj:    configMap("cm", map[string]any{"password": "topsecret"}, nil),
+			clusterObj: &unstructured.Unstructured{},
+			status:     StatusNew,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := diffObjects(tt.fileObj, tt.clusterObj, clusterLabels, paths)
+			if err != nil {
+				t.Fatalf("diffObjects() unexpected error = %v", err)
+			}
+			if res.Status != tt.status {
+				t.Errorf("diffObjects() status = %v, expected %v", res.Status, tt.status)
+			}
+			for _, secret := range []string{"topsecret", "newsecret", "oldsecret"} {
+				if strings.Contains(res.Diff, secret) {
+					t.Errorf("diffObjects() diff contains decrypted value %q:\n%s", secret, res.Diff)
+				}
+			}
+			if tt.changes != nil && !reflect.DeepEqual(res.Changes, tt.changes) {
+				t.Errorf("diffObjects() changes = %v, expected %v", res.Changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestSecretsMetadata(t *testing.T) {
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lastApplied := `{"apiVersion":"v1","kind":"Secret","metadata":{"name":"applied"},"type":"Opaque","data":{"a":"dmFsdWU="}}`
+	scheme := fakemetadata.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	metadataClient := fakemetadata.NewSimpleMetadataClient(scheme,
+		&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "applied", Namespace: "test", Annotations: map[string]string{"kubectl.kubernetes.io/last-applied-configuration": lastApplied}},
+		},
+		&metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: metav1.ObjectMeta{Name: "labeled", Namespace: "test", Labels: map[string]string{"app": "old"}},
+		},
+	)
+	secret := func(name string, labels map[string]any, data map[string]any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]any{"name": name, "labels": labels},
+			"type":       "Opaque",
+			"data":       data,
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		fileObj *unstructured.Unstructured
+		status  Status
+	}{
+		{name: "same keys, unknown values", fileObj: secret("applied", nil, map[string]any{"a": "Y2hhbmdlZA=="}), status: StatusUnchanged},
+		{name: "key added", fileObj: secret("applied", nil, map[string]any{"a": "dmFsdWU=", "b": "dmFsdWU="}), status: StatusChanged},
+		{name: "keys unknown, label changed", fileObj: secret("labeled", map[string]any{"app": "new"}, map[string]any{"a": "dmFsdWU="}), status: StatusChanged},
+		{name: "keys unknown, same labels", fileObj: secret("labeled", map[string]any{"app": "old"}, map[string]any{"a": "dmFsdWU="}), status: StatusUnchanged},
+		{name: "missing", fileObj: secret("missing", nil, map[string]any{"a": "dmFsdWU="}), status: StatusNew},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Diff{SecretsMetadata: true, Namespace: "test", Filter: f}
+			d.cluster = newClusterSource(nil, nil)
+			d.cluster.metadata = metadataClient
+			res, err := d.diffSecretMetadata(tt.fileObj, "test")
+			if err != nil {
+				t.Fatalf("diffSecretMetadata() unexpected error = %v", err)
+			}
+			if res.Status != tt.status {
+				t.Errorf("diffSecretMetadata() status = %v, expected %v\n%s", res.Status, tt.status, res.Diff)
+			}
+		})
+	}
+}
+
+func TestSecretsMetadataList(t *testing.T) {
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileObj := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]any{"name": "s"},
+		"data":       map[string]any{"a": "dmFsdWU="},
+	}}
+	scheme := fakemetadata.NewTestScheme()
+	if err := metav1.AddMetaToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("listed once for concurrent calls", func(t *testing.T) {
+		metadataClient := fakemetadata.NewSimpleMetadataClient(scheme)
+		var lists atomic.Int32
+		metadataClient.PrependReactor("list", "secrets", func(clienttesting.Action) (bool, runtime.Object, error) {
+			lists.Add(1)
+			return false, nil, nil
+		})
+		cluster := newClusterSource(nil, nil)
+		cluster.metadata = metadataClient
+		var wg sync.WaitGroup
+		for range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, _, err := cluster.getSecretMetadata("test", "s"); err != nil {
+					t.Errorf("getSecretMetadata() unexpected error = %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := lists.Load(); n != 1 {
+			t.Errorf("getSecretMetadata() listed %d times, expected 1", n)
+		}
+	})
+
+	t.Run("forbidden list skips the secret", func(t *testing.T) {
+		metadataClient := fakemetadata.NewSimpleMetadataClient(scheme)
+		metadataClient.PrependReactor("list", "secrets", func(clienttesting.Action) (bool, runtime.Object, error) {
+			return true, nil, errors.NewForbidden(secretsGVR.GroupResource(), "", nil)
+		})
+		d := &Diff{SecretsMetadata: true, Namespace: "test", Filter: f, cluster: newClusterSource(nil, nil)}
+		d.cluster.metadata = metadataClient
+		res, err := d.diffSecretMetadata(fileObj, "test")
+		if err != nil {
+			t.Fatalf("diffSecretMetadata() unexpected error = %v", err)
+		}
+		if res.Status != StatusSkipped || res.Error == "" {
+			t.Errorf("diffSecretMetadata() = %v, %q, expected skipped with error", res.Status, res.Error)
+		}
+	})
+}
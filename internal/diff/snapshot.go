@@ -0,0 +1,316 @@
+package diff
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sepich/kubediff/internal/filter"
+	"github.com/sepich/kubediff/internal/store"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	kyaml "sigs.k8s.io/yaml"
+)
+
+const snapshotIndex = "index.yaml"
+
+// snapshotIndexData describes resources in the snapshot, to resolve kinds without the cluster
+type snapshotIndexData struct {
+	Namespace string             `json:"namespace,omitempty"`
+	Resources []snapshotResource `json:"resources"`
+}
+
+type snapshotResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Resource   string `json:"resource"`
+	Namespaced bool   `json:"namespaced"`
+}
+
+// snapshotSource reads "cluster" objects from a snapshot saved by Diff.Snapshot
+type snapshotSource struct {
+	namespace string
+	resources map[schema.GroupVersionKind]snapshotResource
+	objects   map[cacheKey]*unstructured.Unstructured
+}
+
+func (s *snapshotSource) getGVRAndScope(gvk schema.GroupVersionKind) (*schema.GroupVersionResource, bool, error) {
+	r, ok := s.resources[gvk]
+	if !ok {
+		return nil, false, fmt.Errorf("resource not found in snapshot for kind %s", gvk.Kind)
+	}
+	return &schema.GroupVersionResource{Group: gvk.Group, Version: gvk.Version, Resource: r.Resource}, r.Namespaced, nil
+}
+
+func (s *snapshotSource) getObject(gvr schema.GroupVersionResource, isNamespaced bool, namespace, name string) (*unstructured.Unstructured, error) {
+	if !isNamespaced {
+		namespace = ""
+	}
+	if obj, ok := s.objects[cacheKey{gvr: gvr, namespace: namespace, name: name}]; ok {
+		return obj.DeepCopy(), nil
+	}
+	return &unstructured.Unstructured{}, nil
+}
+
+// Snapshot saves cluster objects corresponding to the files into a directory, or a tarball if path ends with .tar.gz or .tgz.
+// The snapshot can later be used via AgainstSnapshot to compare files without cluster access.
+func (d *Diff) Snapshot(path string) (int, error) {
+	var err error
+	if d.cluster, err = d.getClients(); err != nil {
+		return 2, fmt.Errorf("failed to get clients: %w", err)
+	}
+	d.source = d.cluster
+
+	items, err := d.readItems()
+	if err != nil {
+		return 2, err
+	}
+	if d.Prefetch {
+		d.prefetch(items)
+	}
+
+	b, err := d.snapshotItems(items)
+	if err != nil {
+		return 2, err
+	}
+	data, err := kyaml.Marshal(b.index)
+	if err != nil {
+		return 2, fmt.Errorf("failed to marshal snapshot index: %w", err)
+	}
+	b.files[snapshotIndex] = data
+	if err := writeSnapshot(path, b.files); err != nil {
+		return 2, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return 0, nil
+}
+
+// snapshotItems collects cluster objects corresponding to the items, and Secrets unsealed from SealedSecrets
+func (d *Diff) snapshotItems(items []item) (*snapshotBuilder, error) {
+	b := &snapshotBuilder{
+		index: snapshotIndexData{Namespace: d.Namespace},
+		seen:  make(map[schema.GroupVersionKind]bool),
+		files: make(map[string][]byte),
+	}
+	for _, it := range items {
+		gvk := it.obj.GroupVersionKind()
+		if gvk.Kind == "Secret" && gvk.Group == "" && d.SkipSecrets {
+			fmt.Fprintf(os.Stderr, "Skipping Secret: %s/%s\n", d.Namespace, it.obj.GetName())
+			continue
+		}
+		if err := d.snapshotObject(b, it.obj); err != nil {
+			return nil, err
+		}
+		// SealedSecret is compared with the Secret unsealed by the controller, see getLiveSecret
+		if isSealedSecret(it.obj) && !d.SkipSecrets {
+			err := d.snapshotObject(b, sealedSecretTemplate(it.obj))
+			if apierrors.IsForbidden(err) {
+				fmt.Fprintf(os.Stderr, "Warning: reading Secret %s unsealed from SealedSecret is forbidden, it is not saved to the snapshot\n", it.obj.GetName())
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+// snapshotBuilder collects cluster objects and their resources to save in the snapshot
+type snapshotBuilder struct {
+	index snapshotIndexData
+	seen  map[schema.GroupVersionKind]bool
+	files map[string][]byte
+}
+
+// snapshotObject adds the cluster object with the same name as fileObj to the snapshot, if it exists
+func (d *Diff) snapshotObject(b *snapshotBuilder, fileObj *unstructured.Unstructured) error {
+	gvk := fileObj.GroupVersionKind()
+	gvr, isNamespaced, err := d.cluster.getGVRAndScope(gvk)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not find GVR for %s: %v\n", gvk.String(), err)
+		return nil
+	}
+	namespace := d.resolveNamespace(fileObj, isNamespaced)
+	obj, err := d.cluster.getObject(*gvr, isNamespaced, namespace, fileObj.GetName())
+	if err != nil {
+		return fmt.Errorf("failed to get object %s/%s from cluster: %w", gvk.Kind, fileObj.GetName(), err)
+	}
+	// resource is indexed only when readable, so missing object means it does not exist in the cluster
+	if !b.seen[gvk] {
+		b.seen[gvk] = true
+		b.index.Resources = append(b.index.Resources, snapshotResource{
+			APIVersion: gvk.GroupVersion().String(),
+			Kind:       gvk.Kind,
+			Resource:   gvr.Resource,
+			Namespaced: isNamespaced,
+		})
+	}
+	if len(obj.Object) == 0 {
+		return nil
+	}
+	filter.Normalize(obj)
+	dir := "_cluster"
+	if isNamespaced {
+		obj.SetNamespace(namespace)
+		dir = namespace
+	}
+	data, err := kyaml.Marshal(obj.Object)
+	if err != nil {
+		return fmt.Errorf("failed to marshal object: %w", err)
+	}
+	fn := fmt.Sprintf("%s/%s.%s/%s.yaml", dir, gvk.Kind, strings.ReplaceAll(gvk.GroupVersion().String(), "/", "_"), obj.GetName())
+	b.files[fn] = data
+	return nil
+}
+
+func isTarball(path string) bool {
+	return strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz")
+}
+
+// writeSnapshot saves files to the directory or tarball, readable only by the owner as Secrets are stored as is
+func writeSnapshot(path string, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for fn := range files {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+
+	if !isTarball(path) {
+		for _, fn := range names {
+			full := filepath.Join(path, filepath.FromSlash(fn))
+			if err := os.MkdirAll(filepath.Dir(full), 0700); err != nil {
+				return err
+			}
+			if err := os.WriteFile(full, files[fn], 0600); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, fn := range names {
+		if err := tw.WriteHeader(&tar.Header{Name: fn, Mode: 0600, Size: int64(len(files[fn]))}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(files[fn]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// readSnapshotFiles returns content of all files in the snapshot directory or tarball
+func readSnapshotFiles(path string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if stat.IsDir() {
+		err := filepath.WalkDir(path, func(fn string, e fs.DirEntry, err error) error {
+			if err != nil || e.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(path, fn)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)], err = os.ReadFile(fn)
+			return err
+		})
+		return files, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if files[h.Name], err = io.ReadAll(tr); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// loadSnapshot reads snapshot saved by Diff.Snapshot
+func loadSnapshot(path string) (*snapshotSource, error) {
+	files, err := readSnapshotFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := files[snapshotIndex]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in snapshot", snapshotIndex)
+	}
+	var index snapshotIndexData
+	if err := kyaml.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", snapshotIndex, err)
+	}
+
+	s := &snapshotSource{
+		namespace: index.Namespace,
+		resources: make(map[schema.GroupVersionKind]snapshotResource),
+		objects:   make(map[cacheKey]*unstructured.Unstructured),
+	}
+	for _, r := range index.Resources {
+		s.resources[schema.FromAPIVersionAndKind(r.APIVersion, r.Kind)] = r
+	}
+	for fn, data := range files {
+		if fn == snapshotIndex {
+			continue
+		}
+		for obj := range store.YamlToObj(bytes.NewReader(data)) {
+			if obj == nil {
+				return nil, fmt.Errorf("failed to decode YAML from %s", fn)
+			}
+			gvr, isNamespaced, err := s.getGVRAndScope(obj.GroupVersionKind())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", fn, err)
+			}
+			namespace := obj.GetNamespace()
+			if !isNamespaced {
+				namespace = ""
+			}
+			s.objects[cacheKey{gvr: *gvr, namespace: namespace, name: obj.GetName()}] = obj
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,142 @@
+package diff
+
+import (
+	"io/fs"
+	"maps"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/sepich/kubediff/internal/filter"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clienttesting "k8s.io/client-go/testing"
+	kyaml "sigs.k8s.io/yaml"
+)
+
+func TestSnapshot(t *testing.T) {
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		snapshotIndex:                  []byte("namespace: default\nresources:\n- apiVersion: v1\n  kind: ConfigMap\n  resource: configmaps\n  namespaced: true\n"),
+		"default/ConfigMap.v1/cm.yaml": []byte("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n  namespace: default\ndata:\n  key: value\n"),
+	}
+
+	for _, path := range []string{filepath.Join(t.TempDir(), "dir"), filepath.Join(t.TempDir(), "snapshot.tgz")} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			if err := writeSnapshot(path, files); err != nil {
+				t.Fatalf("writeSnapshot() unexpected error = %v", err)
+			}
+			err := filepath.WalkDir(path, func(fn string, e fs.DirEntry, err error) error {
+				if err != nil || e.IsDir() {
+					return err
+				}
+				if info, err := e.Info(); err != nil || info.Mode().Perm() != 0600 {
+					t.Errorf("writeSnapshot() %s mode = %v, expected 0600", fn, info.Mode().Perm())
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			snapshot, err := loadSnapshot(path)
+			if err != nil {
+				t.Fatalf("loadSnapshot() unexpected error = %v", err)
+			}
+			d := &Diff{Filter: f, Namespace: snapshot.namespace, source: snapshot}
+
+			for value, hasDiff := range map[string]bool{"value": false, "changed": true} {
+				res, err := d.diffObject(configMap("cm", map[string]any{"key": value}, nil), nil)
+				if err != nil {
+					t.Fatalf("diffObject() unexpected error = %v", err)
+				}
+				if res.HasDiff() != hasDiff {
+					t.Errorf("diffObject(%s) diff = %v, expected %v", value, res.HasDiff(), hasDiff)
+				}
+			}
+		})
+	}
+}
+
+func TestSnapshotSealedSecret(t *testing.T) {
+	sealed := func(app string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "bitnami.com/v1alpha1",
+			"kind":       "SealedSecret",
+			"metadata":   map[string]any{"name": "s", "namespace": "default"},
+			"spec": map[string]any{
+				"encryptedData": map[string]any{"a": "AgA1"},
+				"template":      map[string]any{"metadata": map[string]any{"labels": map[string]any{"app": app}}, "type": "Opaque"},
+			},
+		}}
+	}
+	secret := &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]any{"name": "s", "namespace": "default", "labels": map[string]any{"app": "live"}},
+		"type":       "Opaque",
+		"data":       map[string]any{"a": "dmFsdWU="},
+	}}
+	sealedSecrets := fakeResource{gvr: schema.GroupVersionResource{Group: "bitnami.com", Version: "v1alpha1", Resource: "sealedsecrets"}, kind: "SealedSecret", namespaced: true}
+	f, err := filter.NewFilter(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		forbidden bool
+		app       string
+		status    Status
+	}{
+		{name: "unsealed Secret unchanged", app: "live", status: StatusUnchanged},
+		{name: "drift of unsealed Secret", app: "file", status: StatusChanged},
+		{name: "Secrets forbidden", forbidden: true, app: "file", status: StatusSkipped},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// SealedSecret in the cluster is the same as in file, only the unsealed Secret differs
+			cluster, dynamicClient := newFakeCluster([]fakeResource{secrets, sealedSecrets}, sealed(tt.app), secret.DeepCopy())
+			if tt.forbidden {
+				dynamicClient.PrependReactor("get", "secrets", func(clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.NewForbidden(secretsGVR.GroupResource(), "s", nil)
+				})
+			}
+			d := &Diff{Namespace: "default", Filter: f, cluster: cluster, source: cluster}
+			b, err := d.snapshotItems([]item{{obj: sealed(tt.app), source: "s.yaml"}})
+			if err != nil {
+				t.Fatalf("snapshotItems() unexpected error = %v", err)
+			}
+			if b.files["default/Secret.v1/s.yaml"] == nil && !tt.forbidden {
+				t.Errorf("snapshotItems() unsealed Secret is not saved, files: %v", slices.Collect(maps.Keys(b.files)))
+			}
+			index, err := kyaml.Marshal(b.index)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.files[snapshotIndex] = index
+			path := filepath.Join(t.TempDir(), "snapshot")
+			if err := writeSnapshot(path, b.files); err != nil {
+				t.Fatalf("writeSnapshot() unexpected error = %v", err)
+			}
+			snapshot, err := loadSnapshot(path)
+			if err != nil {
+				t.Fatalf("loadSnapshot() unexpected error = %v", err)
+			}
+
+			offline := &Diff{Filter: f, Namespace: snapshot.namespace, source: snapshot}
+			res, err := offline.diffObject(sealed(tt.app), nil)
+			if err != nil {
+				t.Fatalf("diffObject() unexpected error = %v", err)
+			}
+			if res.Status != tt.status {
+				t.Errorf("diffObject() status = %v, expected %v\n%s", res.Status, tt.status, res.Diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+const contextLines = 3
+
+type edit struct {
+	op   byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff renders a unified diff (like `diff -u -N`) between two texts, returns empty string if texts are equal
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	edits := diffLines(splitLines(oldText), splitLines(newText))
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+	for _, h := range hunks(edits) {
+		oldStart, oldCount, newStart, newCount := h.oldStart, 0, h.newStart, 0
+		for _, e := range edits[h.from:h.to] {
+			if e.op != '+' {
+				oldCount++
+			}
+			if e.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(oldStart, oldCount), hunkRange(newStart, newCount))
+		for _, e := range edits[h.from:h.to] {
+			sb.WriteByte(e.op)
+			sb.WriteString(e.line)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// hunkRange formats hunk position the same way as GNU diff does
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+type hunk struct {
+	from, to           int // range in edits
+	oldStart, newStart int // 1-based line numbers
+}
+
+// hunks groups edits to ranges of changes with surrounding context lines
+func hunks(edits []edit) []hunk {
+	var res []hunk
+	oldLine, newLine := 1, 1
+	lines := make([][2]int, len(edits)) // line numbers before each edit
+	for i, e := range edits {
+		lines[i] = [2]int{oldLine, newLine}
+		if e.op != '+' {
+			oldLine++
+		}
+		if e.op != '-' {
+			newLine++
+		}
+	}
+
+	for i := 0; i < len(edits); i++ {
+		if edits[i].op == ' ' {
+			continue
+		}
+		from := max(i-contextLines, 0)
+		// extend hunk while next change is within 2*context equal lines, like diff -u does
+		last := i
+		for j := i + 1; j < len(edits) && j-last <= 2*contextLines+1; j++ {
+			if edits[j].op != ' ' {
+				last = j
+			}
+		}
+		to := min(last+contextLines+1, len(edits))
+		res = append(res, hunk{from: from, to: to, oldStart: lines[from][0], newStart: lines[from][1]})
+		i = last
+	}
+	return res
+}
+
+// diffLines finds the shortest edit script between a and b using Myers' algorithm
+func diffLines(a, b []string) []edit {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		res := make([]edit, 0, n+m)
+		for _, l := range a {
+			res = append(res, edit{'-', l})
+		}
+		for _, l := range b {
+			res = append(res, edit{'+', l})
+		}
+		return res
+	}
+
+	offset := n + m
+	v := make([]int, 2*offset+2)
+	var trace [][]int // v[-d..d] snapshot before each step d
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	// backtrack from the end
+	var rev []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snap := trace[d]
+		at := func(k int) int { return snap[k+d] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := 0
+		if d > 0 {
+			prevX = at(prevK)
+		}
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			rev = append(rev, edit{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				rev = append(rev, edit{'+', b[y-1]})
+			} else {
+				rev = append(rev, edit{'-', a[x-1]})
+			}
+			x, y = prevX, prevY
+		}
+	}
+
+	res := make([]edit, len(rev))
+	for i, e := range rev {
+		res[len(rev)-1-i] = e
+	}
+	return res
+}
@@ -0,0 +1,58 @@
+package diff
+
+import (
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		expected string
+	}{
+		{
+			name:     "equal",
+			old:      "a\nb\n",
+			new:      "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "new object",
+			old:      "",
+			new:      "a\nb\n",
+			expected: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "changed line with context",
+			old:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			new:      "1\n2\n3\n4\nX\n6\n7\n8\n9\n",
+			expected: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+X\n 6\n 7\n 8\n",
+		},
+		{
+			name:     "separate hunks",
+			old:      "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			new:      "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			expected: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name:     "changes separated by 2*context lines",
+			old:      "a\n1\n2\n3\n4\n5\n6\nb\n",
+			new:      "A\n1\n2\n3\n4\n5\n6\nB\n",
+			expected: "--- old\n+++ new\n@@ -1,8 +1,8 @@\n-a\n+A\n 1\n 2\n 3\n 4\n 5\n 6\n-b\n+B\n",
+		},
+		{
+			name:     "insert and delete",
+			old:      "a\nb\nc\n",
+			new:      "a\nc\nd\n",
+			expected: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n c\n+d\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := unifiedDiff("old", "new", tt.old, tt.new)
+			if got != tt.expected {
+				t.Errorf("unifiedDiff() got:\n%s\nexpected:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
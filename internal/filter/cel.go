@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// predicate is a compiled CEL expression of the rule `when` field. Variables are:
+// object - the file object, or the cluster one when missing in files; file and cluster - both objects, empty map when missing
+type predicate struct {
+	expr    string
+	program cel.Program
+}
+
+var (
+	celEnv     *cel.Env
+	celEnvErr  error
+	celEnvOnce sync.Once
+)
+
+func compilePredicate(expr string) (*predicate, error) {
+	celEnvOnce.Do(func() {
+		celEnv, celEnvErr = cel.NewEnv(
+			cel.Variable("object", cel.DynType),
+			cel.Variable("file", cel.DynType),
+			cel.Variable("cluster", cel.DynType),
+		)
+	})
+	if celEnvErr != nil {
+		return nil, celEnvErr
+	}
+	ast, iss := celEnv.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expected bool result, got %s", ast.OutputType())
+	}
+	program, err := celEnv.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &predicate{expr: expr, program: program}, nil
+}
+
+// eval returns result of the predicate. Evaluation errors, like missing field without has(), are reported once and mean false.
+func (p *predicate) eval(obj, fileObj, clusterObj *unstructured.Unstructured, warned *sync.Map) bool {
+	out, _, err := p.program.Eval(map[string]any{
+		"object":  objectOrEmpty(obj),
+		"file":    objectOrEmpty(fileObj),
+		"cluster": objectOrEmpty(clusterObj),
+	})
+	if err == nil {
+		if res, ok := out.Value().(bool); ok {
+			return res
+		}
+		err = fmt.Errorf("expected bool result, got %v", out.Value())
+	}
+	if _, ok := warned.LoadOrStore("when:"+p.expr, true); !ok {
+		fmt.Fprintf(os.Stderr, "Warning: failed to evaluate when %q for %s/%s: %v, rule skipped\n", p.expr, obj.GetKind(), obj.GetName(), err)
+	}
+	return false
+}
+
+func objectOrEmpty(obj *unstructured.Unstructured) map[string]any {
+	if obj == nil || obj.Object == nil {
+		return map[string]any{}
+	}
+	return obj.Object
+}
@@ -0,0 +1,489 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+// typedDefaults are built-in kinds which can be defaulted locally, with functions equivalent to
+// SetObjectDefaults_* of k8s.io/kubernetes/pkg/apis/*/v1 run by the api server
+var typedDefaults = map[schema.GroupVersionKind]func() (runtime.Object, func()){
+	corev1.SchemeGroupVersion.WithKind("Pod"): func() (runtime.Object, func()) {
+		o := &corev1.Pod{}
+		return o, func() { setDefaultsPod(o) }
+	},
+	corev1.SchemeGroupVersion.WithKind("Service"): func() (runtime.Object, func()) {
+		o := &corev1.Service{}
+		return o, func() { setDefaultsService(o) }
+	},
+	corev1.SchemeGroupVersion.WithKind("ReplicationController"): func() (runtime.Object, func()) {
+		o := &corev1.ReplicationController{}
+		return o, func() {
+			if o.Spec.Replicas == nil {
+				o.Spec.Replicas = ptr.To[int32](1)
+			}
+			setDefaultsPodTemplate(o.Spec.Template)
+		}
+	},
+	appsv1.SchemeGroupVersion.WithKind("Deployment"): func() (runtime.Object, func()) {
+		o := &appsv1.Deployment{}
+		return o, func() { setDefaultsDeployment(o) }
+	},
+	appsv1.SchemeGroupVersion.WithKind("StatefulSet"): func() (runtime.Object, func()) {
+		o := &appsv1.StatefulSet{}
+		return o, func() { setDefaultsStatefulSet(o) }
+	},
+	appsv1.SchemeGroupVersion.WithKind("DaemonSet"): func() (runtime.Object, func()) {
+		o := &appsv1.DaemonSet{}
+		return o, func() { setDefaultsDaemonSet(o) }
+	},
+	appsv1.SchemeGroupVersion.WithKind("ReplicaSet"): func() (runtime.Object, func()) {
+		o := &appsv1.ReplicaSet{}
+		return o, func() {
+			if o.Spec.Replicas == nil {
+				o.Spec.Replicas = ptr.To[int32](1)
+			}
+			setDefaultsPodTemplate(&o.Spec.Template)
+		}
+	},
+	batchv1.SchemeGroupVersion.WithKind("Job"): func() (runtime.Object, func()) {
+		o := &batchv1.Job{}
+		return o, func() { setDefaultsJob(o) }
+	},
+	batchv1.SchemeGroupVersion.WithKind("CronJob"): func() (runtime.Object, func()) {
+		o := &batchv1.CronJob{}
+		return o, func() { setDefaultsCronJob(o) }
+	},
+	networkingv1.SchemeGroupVersion.WithKind("NetworkPolicy"): func() (runtime.Object, func()) {
+		o := &networkingv1.NetworkPolicy{}
+		return o, func() { setDefaultsNetworkPolicy(o) }
+	},
+}
+
+// SetDefaults sets default values of missing fields for built-in kinds, like the api server does.
+// The object is converted to the typed one and back, and only the defaulted fields are added, to keep the rest as is.
+// Returns false if the kind is not supported.
+func SetDefaults(obj *unstructured.Unstructured) (bool, error) {
+	newTyped, ok := typedDefaults[obj.GroupVersionKind()]
+	if !ok {
+		return false, nil
+	}
+	typed, setDefaults := newTyped()
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+		return true, fmt.Errorf("failed to convert %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	converted, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+	if err != nil {
+		return true, fmt.Errorf("failed to convert %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	setDefaults()
+	defaulted, err := runtime.DefaultUnstructuredConverter.ToUnstructured(typed)
+	if err != nil {
+		return true, fmt.Errorf("failed to convert %s/%s: %w", obj.GetKind(), obj.GetName(), err)
+	}
+	mergeMissing(obj.Object, defaulted, converted)
+	return true, nil
+}
+
+// mergeMissing adds fields of defaulted which are missing in dst, recursively.
+// Fields equal in converted (like empty status or creationTimestamp: null) are artifacts of the conversion and skipped.
+// List elements are matched by index, as defaulting does not change the length.
+func mergeMissing(dst, defaulted, converted map[string]any) {
+	for k, dv := range defaulted {
+		cv := converted[k]
+		v, ok := dst[k]
+		if !ok {
+			if !equality.Semantic.DeepEqual(dv, cv) {
+				dst[k] = dv
+			}
+			continue
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			dm, _ := dv.(map[string]any)
+			cm, _ := cv.(map[string]any)
+			mergeMissing(v, dm, cm)
+		case []any:
+			dl, _ := dv.([]any)
+			cl, _ := cv.([]any)
+			if len(dl) != len(v) || len(cl) != len(v) {
+				continue
+			}
+			for i := range v {
+				m, _ := v[i].(map[string]any)
+				dm, _ := dl[i].(map[string]any)
+				cm, _ := cl[i].(map[string]any)
+				if m != nil {
+					mergeMissing(m, dm, cm)
+				}
+			}
+		}
+	}
+}
+
+func setDefaultsPod(o *corev1.Pod) {
+	if o.Spec.EnableServiceLinks == nil {
+		o.Spec.EnableServiceLinks = ptr.To(corev1.DefaultEnableServiceLinks)
+	}
+	setDefaultsPodSpec(&o.Spec)
+	// requests default to limits
+	for _, containers := range [][]corev1.Container{o.Spec.InitContainers, o.Spec.Containers} {
+		for i := range containers {
+			r := &containers[i].Resources
+			for name, limit := range r.Limits {
+				if r.Requests == nil {
+					r.Requests = corev1.ResourceList{}
+				}
+				if _, ok := r.Requests[name]; !ok {
+					r.Requests[name] = limit.DeepCopy()
+				}
+			}
+		}
+	}
+}
+
+func setDefaultsPodTemplate(t *corev1.PodTemplateSpec) {
+	if t != nil {
+		setDefaultsPodSpec(&t.Spec)
+	}
+}
+
+func setDefaultsPodSpec(s *corev1.PodSpec) {
+	if s.DNSPolicy == "" {
+		s.DNSPolicy = corev1.DNSClusterFirst
+	}
+	if s.RestartPolicy == "" {
+		s.RestartPolicy = corev1.RestartPolicyAlways
+	}
+	if s.SecurityContext == nil {
+		s.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if s.TerminationGracePeriodSeconds == nil {
+		s.TerminationGracePeriodSeconds = ptr.To[int64](corev1.DefaultTerminationGracePeriodSeconds)
+	}
+	if s.SchedulerName == "" {
+		s.SchedulerName = corev1.DefaultSchedulerName
+	}
+	// deprecated serviceAccount field is synced with serviceAccountName
+	if s.ServiceAccountName == "" {
+		s.ServiceAccountName = s.DeprecatedServiceAccount
+	}
+	if s.DeprecatedServiceAccount == "" {
+		s.DeprecatedServiceAccount = s.ServiceAccountName
+	}
+	for i := range s.InitContainers {
+		setDefaultsContainer(&s.InitContainers[i], s.HostNetwork)
+	}
+	for i := range s.Containers {
+		setDefaultsContainer(&s.Containers[i], s.HostNetwork)
+	}
+	for i := range s.Volumes {
+		setDefaultsVolume(&s.Volumes[i])
+	}
+}
+
+func setDefaultsContainer(c *corev1.Container, hostNetwork bool) {
+	if c.ImagePullPolicy == "" {
+		c.ImagePullPolicy = corev1.PullIfNotPresent
+		if imageTag(c.Image) == "latest" {
+			c.ImagePullPolicy = corev1.PullAlways
+		}
+	}
+	if c.TerminationMessagePath == "" {
+		c.TerminationMessagePath = corev1.TerminationMessagePathDefault
+	}
+	if c.TerminationMessagePolicy == "" {
+		c.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+	}
+	for i := range c.Ports {
+		p := &c.Ports[i]
+		if p.Protocol == "" {
+			p.Protocol = corev1.ProtocolTCP
+		}
+		if hostNetwork && p.HostPort == 0 {
+			p.HostPort = p.ContainerPort
+		}
+	}
+	for _, p := range []*corev1.Probe{c.LivenessProbe, c.ReadinessProbe, c.StartupProbe} {
+		setDefaultsProbe(p)
+	}
+	for i := range c.Env {
+		if f := c.Env[i].ValueFrom; f != nil && f.FieldRef != nil && f.FieldRef.APIVersion == "" {
+			f.FieldRef.APIVersion = "v1"
+		}
+	}
+}
+
+// imageTag returns tag of the image, "latest" if not set, or empty for digest references
+func imageTag(image string) string {
+	if strings.Contains(image, "@") {
+		return ""
+	}
+	i := strings.LastIndex(image, ":")
+	if i == -1 || strings.Contains(image[i:], "/") {
+		return "latest"
+	}
+	return image[i+1:]
+}
+
+func setDefaultsProbe(p *corev1.Probe) {
+	if p == nil {
+		return
+	}
+	if p.TimeoutSeconds == 0 {
+		p.TimeoutSeconds = 1
+	}
+	if p.PeriodSeconds == 0 {
+		p.PeriodSeconds = 10
+	}
+	if p.SuccessThreshold == 0 {
+		p.SuccessThreshold = 1
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = 3
+	}
+	if h := p.HTTPGet; h != nil {
+		if h.Path == "" {
+			h.Path = "/"
+		}
+		if h.Scheme == "" {
+			h.Scheme = corev1.URISchemeHTTP
+		}
+	}
+}
+
+func setDefaultsVolume(v *corev1.Volume) {
+	if v.VolumeSource == (corev1.VolumeSource{}) {
+		v.EmptyDir = &corev1.EmptyDirVolumeSource{}
+	}
+	if v.Projected != nil {
+		for _, s := range v.Projected.Sources {
+			if s.ServiceAccountToken != nil && s.ServiceAccountToken.ExpirationSeconds == nil {
+				s.ServiceAccountToken.ExpirationSeconds = ptr.To[int64](3600)
+			}
+		}
+	}
+	mode := ptr.To[int32](corev1.SecretVolumeSourceDefaultMode)
+	switch {
+	case v.Secret != nil && v.Secret.DefaultMode == nil:
+		v.Secret.DefaultMode = mode
+	case v.ConfigMap != nil && v.ConfigMap.DefaultMode == nil:
+		v.ConfigMap.DefaultMode = mode
+	case v.DownwardAPI != nil && v.DownwardAPI.DefaultMode == nil:
+		v.DownwardAPI.DefaultMode = mode
+	case v.Projected != nil && v.Projected.DefaultMode == nil:
+		v.Projected.DefaultMode = mode
+	case v.HostPath != nil && v.HostPath.Type == nil:
+		v.HostPath.Type = ptr.To(corev1.HostPathUnset)
+	}
+}
+
+func setDefaultsService(o *corev1.Service) {
+	s := &o.Spec
+	if s.SessionAffinity == "" {
+		s.SessionAffinity = corev1.ServiceAffinityNone
+	}
+	if s.Type == "" {
+		s.Type = corev1.ServiceTypeClusterIP
+	}
+	for i := range s.Ports {
+		p := &s.Ports[i]
+		if p.Protocol == "" {
+			p.Protocol = corev1.ProtocolTCP
+		}
+		if p.TargetPort == intstr.FromInt32(0) || p.TargetPort == intstr.FromString("") {
+			p.TargetPort = intstr.FromInt32(p.Port)
+		}
+	}
+	if s.Type != corev1.ServiceTypeExternalName && s.InternalTrafficPolicy == nil {
+		s.InternalTrafficPolicy = ptr.To(corev1.ServiceInternalTrafficPolicyCluster)
+	}
+	if (s.Type == corev1.ServiceTypeNodePort || s.Type == corev1.ServiceTypeLoadBalancer) && s.ExternalTrafficPolicy == "" {
+		s.ExternalTrafficPolicy = corev1.ServiceExternalTrafficPolicyCluster
+	}
+	if s.Type == corev1.ServiceTypeLoadBalancer && s.AllocateLoadBalancerNodePorts == nil {
+		s.AllocateLoadBalancerNodePorts = ptr.To(true)
+	}
+}
+
+func setDefaultsDeployment(o *appsv1.Deployment) {
+	s := &o.Spec
+	if s.Replicas == nil {
+		s.Replicas = ptr.To[int32](1)
+	}
+	if s.Strategy.Type == "" {
+		s.Strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+	}
+	if s.Strategy.Type == appsv1.RollingUpdateDeploymentStrategyType {
+		if s.Strategy.RollingUpdate == nil {
+			s.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{}
+		}
+		if s.Strategy.RollingUpdate.MaxUnavailable == nil {
+			s.Strategy.RollingUpdate.MaxUnavailable = ptr.To(intstr.FromString("25%"))
+		}
+		if s.Strategy.RollingUpdate.MaxSurge == nil {
+			s.Strategy.RollingUpdate.MaxSurge = ptr.To(intstr.FromString("25%"))
+		}
+	}
+	if s.RevisionHistoryLimit == nil {
+		s.RevisionHistoryLimit = ptr.To[int32](10)
+	}
+	if s.ProgressDeadlineSeconds == nil {
+		s.ProgressDeadlineSeconds = ptr.To[int32](600)
+	}
+	setDefaultsPodTemplate(&s.Template)
+}
+
+func setDefaultsStatefulSet(o *appsv1.StatefulSet) {
+	s := &o.Spec
+	if s.Replicas == nil {
+		s.Replicas = ptr.To[int32](1)
+	}
+	if s.PodManagementPolicy == "" {
+		s.PodManagementPolicy = appsv1.OrderedReadyPodManagement
+	}
+	if s.UpdateStrategy.Type == "" {
+		s.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+	}
+	if s.UpdateStrategy.Type == appsv1.RollingUpdateStatefulSetStrategyType {
+		if s.UpdateStrategy.RollingUpdate == nil {
+			s.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateStatefulSetStrategy{}
+		}
+		if s.UpdateStrategy.RollingUpdate.Partition == nil {
+			s.UpdateStrategy.RollingUpdate.Partition = ptr.To[int32](0)
+		}
+	}
+	if s.PersistentVolumeClaimRetentionPolicy == nil {
+		s.PersistentVolumeClaimRetentionPolicy = &appsv1.StatefulSetPersistentVolumeClaimRetentionPolicy{}
+	}
+	if s.PersistentVolumeClaimRetentionPolicy.WhenDeleted == "" {
+		s.PersistentVolumeClaimRetentionPolicy.WhenDeleted = appsv1.RetainPersistentVolumeClaimRetentionPolicyType
+	}
+	if s.PersistentVolumeClaimRetentionPolicy.WhenScaled == "" {
+		s.PersistentVolumeClaimRetentionPolicy.WhenScaled = appsv1.RetainPersistentVolumeClaimRetentionPolicyType
+	}
+	if s.RevisionHistoryLimit == nil {
+		s.RevisionHistoryLimit = ptr.To[int32](10)
+	}
+	for i := range s.VolumeClaimTemplates {
+		if s.VolumeClaimTemplates[i].Spec.VolumeMode == nil {
+			s.VolumeClaimTemplates[i].Spec.VolumeMode = ptr.To(corev1.PersistentVolumeFilesystem)
+		}
+	}
+	setDefaultsPodTemplate(&s.Template)
+}
+
+func setDefaultsDaemonSet(o *appsv1.DaemonSet) {
+	s := &o.Spec
+	if s.UpdateStrategy.Type == "" {
+		s.UpdateStrategy.Type = appsv1.RollingUpdateDaemonSetStrategyType
+	}
+	if s.UpdateStrategy.Type == appsv1.RollingUpdateDaemonSetStrategyType {
+		if s.UpdateStrategy.RollingUpdate == nil {
+			s.UpdateStrategy.RollingUpdate = &appsv1.RollingUpdateDaemonSet{}
+		}
+		if s.UpdateStrategy.RollingUpdate.MaxUnavailable == nil {
+			s.UpdateStrategy.RollingUpdate.MaxUnavailable = ptr.To(intstr.FromInt32(1))
+		}
+		if s.UpdateStrategy.RollingUpdate.MaxSurge == nil {
+			s.UpdateStrategy.RollingUpdate.MaxSurge = ptr.To(intstr.FromInt32(0))
+		}
+	}
+	if s.RevisionHistoryLimit == nil {
+		s.RevisionHistoryLimit = ptr.To[int32](10)
+	}
+	setDefaultsPodTemplate(&s.Template)
+}
+
+func setDefaultsJob(o *batchv1.Job) {
+	s := &o.Spec
+	if s.Completions == nil && s.Parallelism == nil {
+		s.Completions = ptr.To[int32](1)
+	}
+	if s.Parallelism == nil {
+		s.Parallelism = ptr.To[int32](1)
+	}
+	if s.BackoffLimit == nil {
+		if s.BackoffLimitPerIndex != nil {
+			s.BackoffLimit = ptr.To[int32](math.MaxInt32)
+		} else {
+			s.BackoffLimit = ptr.To[int32](6)
+		}
+	}
+	if s.CompletionMode == nil {
+		s.CompletionMode = ptr.To(batchv1.NonIndexedCompletion)
+	}
+	if s.Suspend == nil {
+		s.Suspend = ptr.To(false)
+	}
+	if s.PodReplacementPolicy == nil {
+		if s.PodFailurePolicy != nil {
+			s.PodReplacementPolicy = ptr.To(batchv1.Failed)
+		} else {
+			s.PodReplacementPolicy = ptr.To(batchv1.TerminatingOrFailed)
+		}
+	}
+	if s.PodFailurePolicy != nil {
+		for i := range s.PodFailurePolicy.Rules {
+			for j := range s.PodFailurePolicy.Rules[i].OnPodConditions {
+				if p := &s.PodFailurePolicy.Rules[i].OnPodConditions[j]; p.Status == "" {
+					p.Status = corev1.ConditionTrue
+				}
+			}
+		}
+	}
+	setDefaultsPodTemplate(&s.Template)
+}
+
+func setDefaultsCronJob(o *batchv1.CronJob) {
+	s := &o.Spec
+	if s.ConcurrencyPolicy == "" {
+		s.ConcurrencyPolicy = batchv1.AllowConcurrent
+	}
+	if s.Suspend == nil {
+		s.Suspend = ptr.To(false)
+	}
+	if s.SuccessfulJobsHistoryLimit == nil {
+		s.SuccessfulJobsHistoryLimit = ptr.To[int32](3)
+	}
+	if s.FailedJobsHistoryLimit == nil {
+		s.FailedJobsHistoryLimit = ptr.To[int32](1)
+	}
+	setDefaultsPodTemplate(&s.JobTemplate.Spec.Template)
+}
+
+func setDefaultsNetworkPolicy(o *networkingv1.NetworkPolicy) {
+	s := &o.Spec
+	if len(s.PolicyTypes) == 0 {
+		s.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+		if len(s.Egress) > 0 {
+			s.PolicyTypes = append(s.PolicyTypes, networkingv1.PolicyTypeEgress)
+		}
+	}
+	for i := range s.Ingress {
+		setDefaultsNetworkPolicyPorts(s.Ingress[i].Ports)
+	}
+	for i := range s.Egress {
+		setDefaultsNetworkPolicyPorts(s.Egress[i].Ports)
+	}
+}
+
+func setDefaultsNetworkPolicyPorts(ports []networkingv1.NetworkPolicyPort) {
+	for i := range ports {
+		if ports[i].Protocol == nil {
+			ports[i].Protocol = ptr.To(corev1.ProtocolTCP)
+		}
+	}
+}
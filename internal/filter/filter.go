@@ -1,54 +1,165 @@
 package filter
 
 import (
+	"bytes"
 	_ "embed"
 	"errors"
+	"fmt"
 	"os"
+	"reflect"
 	"strings"
+	"sync"
 
 	"github.com/sepich/kubediff/internal/store"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilversion "k8s.io/apimachinery/pkg/version"
 )
 
 //go:embed filter.yml
 var builtinYAML []byte
 
 type Filter struct {
-	filterObjects map[string]*unstructured.Unstructured
+	filterObjects map[schema.GroupVersionKind]*unstructured.Unstructured
+	rules         []rule
+	warned        *sync.Map // warnings already reported, like version fallback of group kinds
 }
 
-func NewFilter(fn string) (*Filter, error) {
-	var err error
+// NewFilter loads built-in filter if builtin is true, and then the files in order on top of it.
+// Documents of the same group, version and kind are deep merged, values from later files win.
+func NewFilter(builtin bool, files ...string) (*Filter, error) {
 	f := &Filter{
-		filterObjects: make(map[string]*unstructured.Unstructured),
+		filterObjects: make(map[schema.GroupVersionKind]*unstructured.Unstructured),
+		warned:        &sync.Map{},
 	}
-	data := builtinYAML
-	if fn != "" {
-		data, err = os.ReadFile(fn)
+	if builtin {
+		if err := f.load(builtinYAML); err != nil {
+			return nil, fmt.Errorf("built-in filter: %w", err)
+		}
+	}
+	for _, fn := range files {
+		data, err := os.ReadFile(fn)
 		if err != nil {
 			return nil, err
 		}
+		if err := f.load(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", fn, err)
+		}
 	}
-	for obj := range store.YamlToObj(strings.NewReader(string(data))) {
+	return f, nil
+}
+
+// load adds filter documents from data as a new layer, conflicting values within the layer are reported
+func (f *Filter) load(data []byte) error {
+	layer := make(map[schema.GroupVersionKind]*unstructured.Unstructured)
+	for obj := range store.YamlToObj(bytes.NewReader(data)) {
 		if obj == nil {
-			return nil, errors.New("failed to decode YAML")
+			return errors.New("failed to decode YAML")
 		}
-		f.filterObjects[obj.GetKind()] = obj
+		if isRulesDoc(obj) {
+			rules, err := parseRules(obj)
+			if err != nil {
+				return err
+			}
+			f.rules = append(f.rules, rules...)
+			continue
+		}
+		gvk := obj.GroupVersionKind()
+		if err := validateHandlers(obj.Object, ""); err != nil {
+			return fmt.Errorf("%s: %w", gvk.String(), err)
+		}
+		if existing, ok := layer[gvk]; ok {
+			mergeFilter(existing.Object, obj.Object, gvk, "", true)
+			continue
+		}
+		layer[gvk] = obj
 	}
-	return f, nil
+
+	for gvk, obj := range layer {
+		if existing, ok := f.filterObjects[gvk]; ok {
+			mergeFilter(existing.Object, obj.Object, gvk, "", false)
+			continue
+		}
+		f.filterObjects[gvk] = obj
+	}
+	return nil
+}
+
+// mergeFilter merges src filter document into dst, values from src win. Conflicting values are reported if warn is true.
+func mergeFilter(dst, src map[string]any, gvk schema.GroupVersionKind, path string, warn bool) {
+	for k, v := range src {
+		p := strings.TrimPrefix(path+"."+k, ".")
+		dv, ok := dst[k]
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, dIsMap := dv.(map[string]any)
+		sm, sIsMap := v.(map[string]any)
+		if dIsMap && sIsMap {
+			mergeFilter(dm, sm, gvk, p, warn)
+			continue
+		}
+		dl, dIsList := dv.([]any)
+		sl, sIsList := v.([]any)
+		if dIsList && sIsList && len(dl) > 0 && len(sl) > 0 {
+			dm, dIsMap := dl[0].(map[string]any)
+			sm, sIsMap := sl[0].(map[string]any)
+			if dIsMap && sIsMap {
+				mergeFilter(dm, sm, gvk, p+"[]", warn)
+				continue
+			}
+		}
+		if warn && !reflect.DeepEqual(dv, v) {
+			fmt.Fprintf(os.Stderr, "Warning: conflicting filter for %s at %s: %v and %v, using the last one\n", gvk.String(), p, dv, v)
+		}
+		dst[k] = v
+	}
+}
+
+// filterFor returns filter document for the object's group, kind and version.
+// When there is no document for the version, the latest version of the same group and kind is used.
+func (f Filter) filterFor(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	gvk := obj.GroupVersionKind()
+	if filterObj, ok := f.filterObjects[gvk]; ok {
+		return filterObj
+	}
+
+	var res *unstructured.Unstructured
+	var version string
+	found := 0
+	for k, filterObj := range f.filterObjects {
+		if k.GroupKind() != gvk.GroupKind() {
+			continue
+		}
+		found++
+		if res == nil || utilversion.CompareKubeAwareVersionStrings(k.Version, version) > 0 {
+			res, version = filterObj, k.Version
+		}
+	}
+	if found > 1 {
+		if _, warned := f.warned.LoadOrStore(gvk.GroupKind(), true); !warned {
+			fmt.Fprintf(os.Stderr, "Warning: no filter for %s, using %s of %d versions found\n", gvk.String(), version, found)
+		}
+	}
+	return res
 }
 
 // Apply applies filtering rules to drop fields from clusterObj, if not set in fileObj
 func (f Filter) Apply(fileObj, clusterObj *unstructured.Unstructured) {
-	normalizeObject(clusterObj)
-	normalizeObject(fileObj)
+	rules := f.matchingRules(fileObj, clusterObj)
+	ignoreRules(rules, fileObj, clusterObj)
+	Normalize(clusterObj)
+	Normalize(fileObj)
 
-	kind := fileObj.GetKind()
-	filterObj, exists := f.filterObjects[kind]
-	if !exists {
-		return
+	if filterObj := f.filterFor(fileObj); filterObj != nil {
+		applyFilteringRecursive(fileObj.Object, clusterObj.Object, filterObj.Object)
+	}
+	for _, r := range rules {
+		if r.filter != nil {
+			applyFilteringRecursive(fileObj.Object, clusterObj.Object, r.filter)
+		}
 	}
-	applyFilteringRecursive(fileObj.Object, clusterObj.Object, filterObj.Object)
 }
 
 // applyFilteringRecursive recursively applies filtering rules
@@ -69,8 +180,10 @@ func applyFilteringRecursive(fileData, clusterData, filterData map[string]any) {
 			if !fileHasKey && (clusterValue == fv || fv == "*") {
 				delete(clusterData, filterKey)
 			}
-			if fv == "$" { //workarounds
-				handleSpecialCases(fileData, clusterData, filterKey, fileHasKey)
+			if strings.HasPrefix(fv, "$") { // computed defaults
+				if h, args, err := handlerFor(filterKey, fv); err == nil {
+					h(fileData, clusterData, filterKey, fileHasKey, args)
+				}
 			}
 		case int64:
 			if !fileHasKey && clusterValue == fv {
@@ -97,14 +210,15 @@ func applyFilteringRecursive(fileData, clusterData, filterData map[string]any) {
 						fileArray = fa
 					}
 				}
-				applyFilteringToArray(fileArray, clusterArray, fv)
+				applyFilteringToArray(filterKey, fileArray, clusterArray, fv)
 			}
 		}
 	}
 }
 
-// applyFilteringToArray applies filtering to array elements
-func applyFilteringToArray(fileArray, clusterArray, filterArray []any) {
+// applyFilteringToArray applies filtering to array elements.
+// Elements are matched by list keys (like name of container) when known, or by index otherwise.
+func applyFilteringToArray(field string, fileArray, clusterArray, filterArray []any) {
 	if len(filterArray) == 0 {
 		return
 	}
@@ -115,11 +229,21 @@ func applyFilteringToArray(fileArray, clusterArray, filterArray []any) {
 		return
 	}
 
+	fileByKey := make(map[string]map[string]any, len(fileArray))
+	fileKeys, keyed := ListKeys(field, fileArray)
+	for i, key := range fileKeys {
+		fileByKey[key], _ = fileArray[i].(map[string]any)
+	}
+
 	// Apply the filter template to each element in the cluster array
 	for i, clusterItem := range clusterArray {
 		if clusterMap, ok := clusterItem.(map[string]any); ok {
 			fileMap := map[string]any{}
-			if i < len(fileArray) {
+			if key, ok := ListKey(field, clusterItem); ok && keyed {
+				if fm := fileByKey[key]; fm != nil {
+					fileMap = fm
+				}
+			} else if i < len(fileArray) {
 				if fm, ok := fileArray[i].(map[string]any); ok {
 					fileMap = fm
 				}
@@ -129,43 +253,8 @@ func applyFilteringToArray(fileArray, clusterArray, filterArray []any) {
 	}
 }
 
-// handleSpecialCases try to duplicate k8s behaviour for some values
-func handleSpecialCases(fileData, clusterData map[string]any, filterKey string, fileHasKey bool) {
-	if filterKey == "serviceAccount" && fileHasKey {
-		if _, ok := fileData["serviceAccountName"]; !ok {
-			delete(clusterData, "serviceAccountName")
-		}
-	}
-	if filterKey == "serviceAccountName" && fileHasKey {
-		if _, ok := fileData["serviceAccount"]; !ok {
-			delete(clusterData, "serviceAccount")
-		}
-	}
-	if filterKey == "imagePullPolicy" && !fileHasKey {
-		val := "IfNotPresent"
-		if image, ok := fileData["image"]; ok {
-			tag := ""
-			j := strings.LastIndex(image.(string), ":")
-			tag = image.(string)[j+1:]
-			if tag == "latest" || j == -1 {
-				val = "Always"
-			}
-		}
-		fileData["imagePullPolicy"] = val
-	}
-	if filterKey == "targetPort" && !fileHasKey {
-		if port, ok := fileData["port"]; ok {
-			fileData["targetPort"] = port
-		}
-	}
-	if filterKey == "listKind" && !fileHasKey {
-		if kind, ok := fileData["kind"]; ok {
-			fileData["listKind"] = kind.(string) + "List"
-		}
-	}
-}
-
-func normalizeObject(obj *unstructured.Unstructured) {
+// Normalize drops server-side fields like status, resourceVersion or managedFields, which are not set in files
+func Normalize(obj *unstructured.Unstructured) {
 	delete(obj.Object, "status")
 
 	if metadata, ok := obj.Object["metadata"].(map[string]interface{}); ok {
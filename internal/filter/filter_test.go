@@ -2,7 +2,10 @@ package filter
 
 import (
 	"github.com/sepich/kubediff/internal/store"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
@@ -190,7 +193,7 @@ status:
 		},
 	}
 
-	filter, err := NewFilter("")
+	filter, err := NewFilter(true)
 	if err != nil {
 		t.Fatalf("failed to create filter: %v", err)
 	}
@@ -219,3 +222,1106 @@ status:
 		})
 	}
 }
+
+func TestRules(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: kubediff/v1
+kind: Rules
+rules:
+- match: {group: apps, kind: Deployment, name: "web-*"}
+  ignore:
+  - spec.template.spec.containers[*].resources
+  - metadata.annotations['argocd.argoproj.io/*']
+- match: {group: "", kind: ConfigMap, namespace: prod}
+  ignore:
+  - data["key.*"]
+  - data.list[1]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(false, fn)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		fileYaml    string
+		clusterYaml string
+		hasDiff     bool
+	}{
+		{
+			name: "ignored container resources and annotations",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web-1
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        resources: {limits: {cpu: 1}}`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web-1
+  annotations:
+    argocd.argoproj.io/sync-wave: "1"
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        resources: {limits: {cpu: 2}}`,
+			hasDiff: false,
+		},
+		{
+			name: "name does not match",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        resources: {limits: {cpu: 1}}`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        resources: {limits: {cpu: 2}}`,
+			hasDiff: true,
+		},
+		{
+			name: "quoted key glob and list index",
+			fileYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: prod
+data:
+  key.a: "1"
+  list: [a, b]
+  other: x`,
+			clusterYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: prod
+data:
+  key.a: "2"
+  key.b: "3"
+  list: [a, c]
+  other: x`,
+			hasDiff: false,
+		},
+		{
+			name: "namespace does not match",
+			fileYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: dev
+data:
+  key.a: "1"`,
+			clusterYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cm
+  namespace: dev
+data:
+  key.a: "2"`,
+			hasDiff: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fileObj, clusterObj *unstructured.Unstructured
+			for obj := range store.YamlToObj(strings.NewReader(tt.fileYaml)) {
+				fileObj = obj
+			}
+			for obj := range store.YamlToObj(strings.NewReader(tt.clusterYaml)) {
+				clusterObj = obj
+			}
+
+			filter.Apply(fileObj, clusterObj)
+			eq := reflect.DeepEqual(fileObj, clusterObj)
+			if eq == tt.hasDiff {
+				t.Errorf("expected diff: %v, got: %v\n%v\n%v", tt.hasDiff, !eq, fileObj, clusterObj)
+			}
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    int
+		wantErr bool
+	}{
+		{path: "spec.template.spec.containers[*].resources", want: 6},
+		{path: "metadata.annotations['argocd.*']", want: 3},
+		{path: `data["a]b"].x`, want: 3},
+		{path: "spec.ports[0]", want: 3},
+		{path: "", wantErr: true},
+		{path: ".spec", wantErr: true},
+		{path: "spec[x]", wantErr: true},
+		{path: "spec['x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parsePath(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parsePath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			continue
+		}
+		if len(got) != tt.want {
+			t.Errorf("parsePath(%q) = %d segments, expected %d", tt.path, len(got), tt.want)
+		}
+	}
+}
+
+func TestFilterKeys(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: cert-manager.io/v1
+kind: Issuer
+spec:
+  a: "*"
+---
+apiVersion: other.io/v1
+kind: Issuer
+spec:
+  b: "*"
+---
+apiVersion: cert-manager.io/v1
+kind: Issuer
+spec:
+  c: "*"
+---
+apiVersion: example.io/v1beta1
+kind: Thing
+spec:
+  old: "*"
+---
+apiVersion: example.io/v2
+kind: Thing
+spec:
+  new: "*"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(false, fn)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		apiVersion string
+		kind       string
+		remaining  []string
+	}{
+		{apiVersion: "cert-manager.io/v1", kind: "Issuer", remaining: []string{"b", "new", "old"}},
+		{apiVersion: "other.io/v1", kind: "Issuer", remaining: []string{"a", "c", "new", "old"}},
+		{apiVersion: "example.io/v1", kind: "Thing", remaining: []string{"a", "b", "c", "old"}},
+		{apiVersion: "example.io/v1beta1", kind: "Thing", remaining: []string{"a", "b", "c", "new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.apiVersion, func(t *testing.T) {
+			fileObj := &unstructured.Unstructured{Object: map[string]any{"apiVersion": tt.apiVersion, "kind": tt.kind}}
+			clusterObj := &unstructured.Unstructured{Object: map[string]any{"apiVersion": tt.apiVersion, "kind": tt.kind,
+				"spec": map[string]any{"a": "1", "b": "1", "c": "1", "old": "1", "new": "1"}}}
+			filter.Apply(fileObj, clusterObj)
+			var remaining []string
+			for k := range clusterObj.Object["spec"].(map[string]any) {
+				remaining = append(remaining, k)
+			}
+			sort.Strings(remaining)
+			if !reflect.DeepEqual(remaining, tt.remaining) {
+				t.Errorf("Apply() remaining fields = %v, expected %v", remaining, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestFilterLayers(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  replicas: 3
+  template:
+    metadata:
+      annotations:
+        sidecar.istio.io/status: "*"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cluster := func() *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"spec": map[string]any{
+				"replicas":             int64(3),
+				"revisionHistoryLimit": int64(10),
+				"template": map[string]any{"metadata": map[string]any{
+					"annotations": map[string]any{"sidecar.istio.io/status": "{}"},
+				}},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name      string
+		builtin   bool
+		files     []string
+		remaining []string
+	}{
+		{name: "builtin", builtin: true, remaining: []string{"replicas", "template"}},
+		{name: "builtin and file", builtin: true, files: []string{fn}, remaining: nil},
+		{name: "file only", builtin: false, files: []string{fn}, remaining: []string{"revisionHistoryLimit"}},
+		{name: "none", builtin: false, remaining: []string{"replicas", "revisionHistoryLimit", "template"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := NewFilter(tt.builtin, tt.files...)
+			if err != nil {
+				t.Fatalf("failed to create filter: %v", err)
+			}
+			fileObj := &unstructured.Unstructured{Object: map[string]any{"apiVersion": "apps/v1", "kind": "Deployment"}}
+			clusterObj := cluster()
+			filter.Apply(fileObj, clusterObj)
+			var remaining []string
+			spec, _ := clusterObj.Object["spec"].(map[string]any)
+			for k := range spec {
+				remaining = append(remaining, k)
+			}
+			sort.Strings(remaining)
+			if !reflect.DeepEqual(remaining, tt.remaining) {
+				t.Errorf("Apply() remaining fields = %v, expected %v", remaining, tt.remaining)
+			}
+		})
+	}
+}
+
+func TestApplyFilteringListKeys(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - terminationMessagePath: "*"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(false, fn)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+	deployment := func(containers ...any) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"spec":       map[string]any{"template": map[string]any{"spec": map[string]any{"containers": containers}}},
+		}}
+	}
+	fileObj := deployment(
+		map[string]any{"name": "app", "terminationMessagePath": "/custom"},
+		map[string]any{"name": "worker"},
+	)
+	clusterObj := deployment(
+		map[string]any{"name": "sidecar", "terminationMessagePath": "/dev/termination-log"},
+		map[string]any{"name": "worker", "terminationMessagePath": "/dev/termination-log"},
+		map[string]any{"name": "app", "terminationMessagePath": "/custom"},
+	)
+	filter.Apply(fileObj, clusterObj)
+
+	expected := deployment(
+		map[string]any{"name": "sidecar"},
+		map[string]any{"name": "worker"},
+		map[string]any{"name": "app", "terminationMessagePath": "/custom"},
+	)
+	if !reflect.DeepEqual(clusterObj, expected) {
+		t.Errorf("Apply() = %v, expected %v", clusterObj, expected)
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileYaml  string
+		expected  string
+		supported bool
+	}{
+		{
+			name: "deployment",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      serviceAccountName: web
+      containers:
+      - name: app
+        image: nginx
+        imagePullPolicy: Always
+        ports:
+        - containerPort: 80
+        readinessProbe:
+          httpGet: {port: 80}
+      - name: sidecar
+        image: envoy:1.30
+        env:
+        - name: POD_IP
+          valueFrom:
+            fieldRef: {fieldPath: status.podIP}
+      volumes:
+      - name: config
+        configMap: {name: web}`,
+			expected: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  progressDeadlineSeconds: 600
+  revisionHistoryLimit: 10
+  strategy:
+    type: RollingUpdate
+    rollingUpdate: {maxSurge: 25%, maxUnavailable: 25%}
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      serviceAccountName: web
+      serviceAccount: web
+      dnsPolicy: ClusterFirst
+      restartPolicy: Always
+      schedulerName: default-scheduler
+      securityContext: {}
+      terminationGracePeriodSeconds: 30
+      containers:
+      - name: app
+        image: nginx
+        imagePullPolicy: Always
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File
+        ports:
+        - containerPort: 80
+          protocol: TCP
+        readinessProbe:
+          httpGet: {port: 80, path: /, scheme: HTTP}
+          timeoutSeconds: 1
+          periodSeconds: 10
+          successThreshold: 1
+          failureThreshold: 3
+      - name: sidecar
+        image: envoy:1.30
+        imagePullPolicy: IfNotPresent
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File
+        env:
+        - name: POD_IP
+          valueFrom:
+            fieldRef: {fieldPath: status.podIP, apiVersion: v1}
+      volumes:
+      - name: config
+        configMap: {name: web, defaultMode: 420}`,
+			supported: true,
+		},
+		{
+			name: "service",
+			fileYaml: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: LoadBalancer
+  ports:
+  - port: 80
+  - port: 443
+    targetPort: https`,
+			expected: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: LoadBalancer
+  sessionAffinity: None
+  internalTrafficPolicy: Cluster
+  externalTrafficPolicy: Cluster
+  allocateLoadBalancerNodePorts: true
+  ports:
+  - port: 80
+    protocol: TCP
+    targetPort: 80
+  - port: 443
+    protocol: TCP
+    targetPort: https`,
+			supported: true,
+		},
+		{
+			name: "cronjob",
+			fileYaml: `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  schedule: "0 * * * *"
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+          containers:
+          - name: backup
+            image: backup@sha256:0000`,
+			expected: `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  schedule: "0 * * * *"
+  concurrencyPolicy: Allow
+  suspend: false
+  successfulJobsHistoryLimit: 3
+  failedJobsHistoryLimit: 1
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          restartPolicy: OnFailure
+          dnsPolicy: ClusterFirst
+          schedulerName: default-scheduler
+          securityContext: {}
+          terminationGracePeriodSeconds: 30
+          containers:
+          - name: backup
+            image: backup@sha256:0000
+            imagePullPolicy: IfNotPresent
+            terminationMessagePath: /dev/termination-log
+            terminationMessagePolicy: File`,
+			supported: true,
+		},
+		{
+			name: "job",
+			fileYaml: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: migrate:1.0`,
+			expected: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  completions: 1
+  parallelism: 1
+  backoffLimit: 6
+  completionMode: NonIndexed
+  suspend: false
+  podReplacementPolicy: TerminatingOrFailed
+  template:
+    spec:
+      restartPolicy: Never
+      dnsPolicy: ClusterFirst
+      schedulerName: default-scheduler
+      securityContext: {}
+      terminationGracePeriodSeconds: 30
+      containers:
+      - name: migrate
+        image: migrate:1.0
+        imagePullPolicy: IfNotPresent
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File`,
+			supported: true,
+		},
+		{
+			name: "job with pod failure policy and backoff limit per index",
+			fileYaml: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  completions: 3
+  completionMode: Indexed
+  backoffLimitPerIndex: 1
+  podFailurePolicy:
+    rules:
+    - action: Ignore
+      onPodConditions:
+      - type: DisruptionTarget
+  template:
+    spec:
+      restartPolicy: Never
+      containers:
+      - name: migrate
+        image: migrate:1.0`,
+			expected: `
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  completions: 3
+  parallelism: 1
+  completionMode: Indexed
+  backoffLimitPerIndex: 1
+  backoffLimit: 2147483647
+  suspend: false
+  podReplacementPolicy: Failed
+  podFailurePolicy:
+    rules:
+    - action: Ignore
+      onPodConditions:
+      - type: DisruptionTarget
+        status: "True"
+  template:
+    spec:
+      restartPolicy: Never
+      dnsPolicy: ClusterFirst
+      schedulerName: default-scheduler
+      securityContext: {}
+      terminationGracePeriodSeconds: 30
+      containers:
+      - name: migrate
+        image: migrate:1.0
+        imagePullPolicy: IfNotPresent
+        terminationMessagePath: /dev/termination-log
+        terminationMessagePolicy: File`,
+			supported: true,
+		},
+		{
+			name: "pod volumes",
+			fileYaml: `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: app
+    image: nginx:1.0
+  volumes:
+  - name: tmp
+  - name: token
+    projected:
+      sources:
+      - serviceAccountToken: {path: token, audience: vault}`,
+			expected: `
+apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  restartPolicy: Always
+  enableServiceLinks: true
+  dnsPolicy: ClusterFirst
+  schedulerName: default-scheduler
+  securityContext: {}
+  terminationGracePeriodSeconds: 30
+  containers:
+  - name: app
+    image: nginx:1.0
+    imagePullPolicy: IfNotPresent
+    terminationMessagePath: /dev/termination-log
+    terminationMessagePolicy: File
+  volumes:
+  - name: tmp
+    emptyDir: {}
+  - name: token
+    projected:
+      defaultMode: 420
+      sources:
+      - serviceAccountToken: {path: token, audience: vault, expirationSeconds: 3600}`,
+			supported: true,
+		},
+		{
+			name: "unsupported kind",
+			fileYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: value`,
+			expected: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: value`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var obj, expected *unstructured.Unstructured
+			for o := range store.YamlToObj(strings.NewReader(tt.fileYaml)) {
+				obj = o
+			}
+			for o := range store.YamlToObj(strings.NewReader(tt.expected)) {
+				expected = o
+			}
+
+			supported, err := SetDefaults(obj)
+			if err != nil {
+				t.Fatalf("SetDefaults() error = %v", err)
+			}
+			if supported != tt.supported {
+				t.Errorf("SetDefaults() = %v, expected %v", supported, tt.supported)
+			}
+			if !reflect.DeepEqual(obj, expected) {
+				t.Errorf("SetDefaults() = %v, expected %v", obj, expected)
+			}
+		})
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: example.com/v1
+kind: Gateway
+spec:
+  listeners:
+  - targetPort: "$copyFrom(port)"
+    name: "$copyFrom(protocol, -listener)"
+  serviceAccount: "$"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(false, fn)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	gateway := func(listener, spec map[string]any) *unstructured.Unstructured {
+		spec["listeners"] = []any{listener}
+		return &unstructured.Unstructured{Object: map[string]any{
+			"apiVersion": "example.com/v1",
+			"kind":       "Gateway",
+			"spec":       spec,
+		}}
+	}
+	fileObj := gateway(
+		map[string]any{"port": int64(80), "protocol": "http"},
+		map[string]any{"serviceAccount": "gw"},
+	)
+	clusterObj := gateway(
+		map[string]any{"port": int64(80), "protocol": "http", "targetPort": int64(80), "name": "http-listener", "image": "gw"},
+		map[string]any{"serviceAccount": "gw", "serviceAccountName": "gw"},
+	)
+	filter.Apply(fileObj, clusterObj)
+
+	expectedFile := gateway(
+		map[string]any{"port": int64(80), "protocol": "http", "targetPort": int64(80), "name": "http-listener"},
+		map[string]any{"serviceAccount": "gw"},
+	)
+	if !reflect.DeepEqual(fileObj, expectedFile) {
+		t.Errorf("Apply() file = %v, expected %v", fileObj, expectedFile)
+	}
+	expectedCluster := gateway(
+		map[string]any{"port": int64(80), "protocol": "http", "targetPort": int64(80), "name": "http-listener", "image": "gw"},
+		map[string]any{"serviceAccount": "gw"},
+	)
+	if !reflect.DeepEqual(clusterObj, expectedCluster) {
+		t.Errorf("Apply() cluster = %v, expected %v", clusterObj, expectedCluster)
+	}
+
+	for _, ref := range []string{`"$unknown"`, `"$copyFrom(port"`, `"$"`} {
+		err := os.WriteFile(fn, []byte("apiVersion: v1\nkind: Service\nspec:\n  field: "+ref+"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFilter(false, fn); err == nil {
+			t.Errorf("NewFilter() with %s expected error", ref)
+		}
+	}
+}
+
+func TestImagePullPolicyHandler(t *testing.T) {
+	tests := []struct {
+		image    string
+		expected string
+	}{
+		{image: "nginx", expected: "Always"},
+		{image: "nginx:latest", expected: "Always"},
+		{image: "nginx:1.27", expected: "IfNotPresent"},
+		{image: "registry:5000/nginx", expected: "Always"},
+		{image: "registry:5000/nginx:1.27", expected: "IfNotPresent"},
+		{image: "nginx@sha256:0000", expected: "IfNotPresent"},
+	}
+	for _, tt := range tests {
+		fileData := map[string]any{"image": tt.image}
+		imagePullPolicyHandler(fileData, nil, "imagePullPolicy", false, nil)
+		if fileData["imagePullPolicy"] != tt.expected {
+			t.Errorf("imagePullPolicy for %q = %v, expected %s", tt.image, fileData["imagePullPolicy"], tt.expected)
+		}
+	}
+}
+
+func TestRulesWhen(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: kubediff/v1
+kind: Rules
+rules:
+- match: {group: "", kind: Service}
+  when: "object.spec.type == 'LoadBalancer'"
+  filter:
+    spec:
+      externalTrafficPolicy: Cluster
+- match: {kind: ConfigMap}
+  when: "has(cluster.metadata.labels) && cluster.metadata.labels['injected'] == 'true'"
+  ignore:
+  - data
+- match: {kind: Deployment}
+  filter:
+    spec:
+      replicas: 1
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(false, fn)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		fileYaml    string
+		clusterYaml string
+		hasDiff     bool
+	}{
+		{
+			name: "load balancer default",
+			fileYaml: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: LoadBalancer`,
+			clusterYaml: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: LoadBalancer
+  externalTrafficPolicy: Cluster`,
+			hasDiff: false,
+		},
+		{
+			name: "when is false",
+			fileYaml: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP`,
+			clusterYaml: `
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  type: ClusterIP
+  externalTrafficPolicy: Cluster`,
+			hasDiff: true,
+		},
+		{
+			name: "when by cluster object",
+			fileYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  labels: {injected: "true"}
+data:
+  key: a`,
+			clusterYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+  labels: {injected: "true"}
+data:
+  key: b`,
+			hasDiff: false,
+		},
+		{
+			name: "no labels in cluster",
+			fileYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: a`,
+			clusterYaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web
+data:
+  key: b`,
+			hasDiff: true,
+		},
+		{
+			name: "integer filter value",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1`,
+			hasDiff: false,
+		},
+		{
+			name: "integer filter value differs",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2`,
+			hasDiff: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fileObj, clusterObj *unstructured.Unstructured
+			for obj := range store.YamlToObj(strings.NewReader(tt.fileYaml)) {
+				fileObj = obj
+			}
+			for obj := range store.YamlToObj(strings.NewReader(tt.clusterYaml)) {
+				clusterObj = obj
+			}
+
+			filter.Apply(fileObj, clusterObj)
+			eq := reflect.DeepEqual(fileObj, clusterObj)
+			if eq == tt.hasDiff {
+				t.Errorf("expected diff: %v, got: %v\n%v\n%v", tt.hasDiff, !eq, fileObj, clusterObj)
+			}
+		})
+	}
+
+	for _, when := range []string{"object.spec.", "'string'"} {
+		err := os.WriteFile(fn, []byte("apiVersion: kubediff/v1\nkind: Rules\nrules:\n- when: \""+when+"\"\n"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := NewFilter(false, fn); err == nil {
+			t.Errorf("NewFilter() with when %q expected error", when)
+		}
+	}
+}
+
+func TestInjected(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "filter.yml")
+	err := os.WriteFile(fn, []byte(`
+apiVersion: kubediff/v1
+kind: Rules
+rules:
+- match: {group: apps}
+  injected:
+    containers: [istio-proxy, "vault-agent*"]
+    initContainers: [istio-init]
+    volumes: ["istio-*"]
+    volumeMounts: ["istio-*"]
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewFilter(false, fn)
+	if err != nil {
+		t.Fatalf("failed to create filter: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		fileYaml    string
+		clusterYaml string
+		hasDiff     bool
+	}{
+		{
+			name: "injected sidecar, init container, volumes and mounts",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        volumeMounts:
+        - name: data
+          mountPath: /data
+      volumes:
+      - name: data`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: istio-init
+      containers:
+      - name: istio-proxy
+        volumeMounts:
+        - name: istio-envoy
+          mountPath: /etc/istio/proxy
+      - name: app
+        volumeMounts:
+        - name: istio-token
+          mountPath: /var/run/secrets/tokens
+        - name: data
+          mountPath: /data
+      - name: vault-agent-sidecar
+      volumes:
+      - name: data
+      - name: istio-envoy`,
+			hasDiff: false,
+		},
+		{
+			name: "element in file is compared",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+      - name: vault-agent
+        image: vault:1.17`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+      - name: vault-agent
+        image: vault:1.18`,
+			hasDiff: true,
+		},
+		{
+			name: "not matching name",
+			fileYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: app`,
+			clusterYaml: `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+      - name: debug`,
+			hasDiff: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fileObj, clusterObj *unstructured.Unstructured
+			for obj := range store.YamlToObj(strings.NewReader(tt.fileYaml)) {
+				fileObj = obj
+			}
+			for obj := range store.YamlToObj(strings.NewReader(tt.clusterYaml)) {
+				clusterObj = obj
+			}
+
+			filter.Apply(fileObj, clusterObj)
+			eq := reflect.DeepEqual(fileObj, clusterObj)
+			if eq == tt.hasDiff {
+				t.Errorf("expected diff: %v, got: %v\n%v\n%v", tt.hasDiff, !eq, fileObj, clusterObj)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Handler computes the default of the field key in fileData, which is missing in the file or depends on other fields,
+// like api server does. args are arguments of the reference in filter, like "port" for "$copyFrom(port)".
+type Handler func(fileData, clusterData map[string]any, key string, fileHasKey bool, args []string)
+
+var (
+	handlers   = map[string]Handler{}
+	handlersMu sync.RWMutex
+)
+
+// legacyHandlers are used for the "$" marker, by name of the field
+var legacyHandlers = map[string]string{
+	"serviceAccount":     "$syncWith(serviceAccountName)",
+	"serviceAccountName": "$syncWith(serviceAccount)",
+	"imagePullPolicy":    "$imagePullPolicy",
+	"targetPort":         "$copyFrom(port)",
+	"listKind":           "$copyFrom(kind,List)",
+}
+
+func init() {
+	RegisterHandler("imagePullPolicy", imagePullPolicyHandler)
+	RegisterHandler("copyFrom", copyFromHandler)
+	RegisterHandler("syncWith", syncWithHandler)
+}
+
+// RegisterHandler makes the handler available in filter files as "$name" or "$name(arg,...)"
+func RegisterHandler(name string, h Handler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	if _, ok := handlers[name]; ok {
+		panic("filter: handler registered twice: " + name)
+	}
+	handlers[name] = h
+}
+
+// parseHandlerRef parses filter value like "$copyFrom(port)" into handler name and arguments.
+// Returns false if the value is not a handler reference.
+func parseHandlerRef(ref string) (string, []string, bool) {
+	if len(ref) < 2 || ref[0] != '$' {
+		return "", nil, false
+	}
+	name, args, hasArgs := strings.Cut(ref[1:], "(")
+	if !hasArgs {
+		return name, nil, true
+	}
+	args, ok := strings.CutSuffix(args, ")")
+	if !ok {
+		return "", nil, false
+	}
+	var res []string
+	for _, a := range strings.Split(args, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			res = append(res, a)
+		}
+	}
+	return name, res, true
+}
+
+// handlerFor returns the handler referenced by filter value, "$" picks the built-in one by field name
+func handlerFor(key, ref string) (Handler, []string, error) {
+	if ref == "$" {
+		if ref = legacyHandlers[key]; ref == "" {
+			return nil, nil, fmt.Errorf("no handler for %q", key)
+		}
+	}
+	name, args, ok := parseHandlerRef(ref)
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid handler reference %q", ref)
+	}
+	handlersMu.RLock()
+	h, ok := handlers[name]
+	handlersMu.RUnlock()
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown handler %q", name)
+	}
+	return h, args, nil
+}
+
+// validateHandlers checks that all handler references in the filter document are known
+func validateHandlers(data map[string]any, path string) error {
+	for k, v := range data {
+		p := strings.TrimPrefix(path+"."+k, ".")
+		switch v := v.(type) {
+		case string:
+			if strings.HasPrefix(v, "$") {
+				if _, _, err := handlerFor(k, v); err != nil {
+					return fmt.Errorf("%s: %w", p, err)
+				}
+			}
+		case map[string]any:
+			if err := validateHandlers(v, p); err != nil {
+				return err
+			}
+		case []any:
+			for _, item := range v {
+				if m, ok := item.(map[string]any); ok {
+					if err := validateHandlers(m, p+"[]"); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// imagePullPolicyHandler sets imagePullPolicy by image tag: Always for latest or missing tag, IfNotPresent otherwise
+func imagePullPolicyHandler(fileData, _ map[string]any, key string, fileHasKey bool, _ []string) {
+	if fileHasKey {
+		return
+	}
+	val := "IfNotPresent"
+	if image, ok := fileData["image"].(string); ok && imageTag(image) == "latest" {
+		val = "Always"
+	}
+	fileData[key] = val
+}
+
+// copyFromHandler sets the field to the value of the sibling field args[0], with optional string suffix args[1]
+func copyFromHandler(fileData, _ map[string]any, key string, fileHasKey bool, args []string) {
+	if fileHasKey || len(args) == 0 {
+		return
+	}
+	v, ok := fileData[args[0]]
+	if !ok {
+		return
+	}
+	if len(args) > 1 {
+		s, ok := v.(string)
+		if !ok {
+			return
+		}
+		v = s + args[1]
+	}
+	fileData[key] = v
+}
+
+// syncWithHandler drops the sibling field args[0] from cluster, when only the field is set in file,
+// for pairs of fields which api server keeps in sync like serviceAccount and serviceAccountName
+func syncWithHandler(fileData, clusterData map[string]any, _ string, fileHasKey bool, args []string) {
+	if !fileHasKey || len(args) == 0 {
+		return
+	}
+	if _, ok := fileData[args[0]]; !ok {
+		delete(clusterData, args[0])
+	}
+}
@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"regexp"
+)
+
+// dropInjected removes elements of the injected lists from clusterVal, like sidecar containers added by admission webhooks,
+// when their name matches and there is no element with the same name in the same list of fileVal.
+// Lists are found by field name at any level, and nested elements are matched by list keys or by index.
+func dropInjected(fileVal, clusterVal any, injected map[string][]*regexp.Regexp) {
+	clusterMap, ok := clusterVal.(map[string]any)
+	if !ok {
+		return
+	}
+	fileMap, _ := fileVal.(map[string]any)
+	for k, cv := range clusterMap {
+		fv, fileHasKey := fileMap[k]
+		clusterList, ok := cv.([]any)
+		if !ok {
+			dropInjected(fv, cv, injected)
+			continue
+		}
+		fileList, _ := fv.([]any)
+		if patterns, ok := injected[k]; ok {
+			clusterList = dropInjectedItems(fileList, clusterList, patterns)
+			if len(clusterList) == 0 && !fileHasKey {
+				delete(clusterMap, k)
+				continue
+			}
+			clusterMap[k] = clusterList
+		}
+
+		fileKeys, fileKeyed := ListKeys(k, fileList)
+		fileByKey := make(map[string]any, len(fileList))
+		for i, key := range fileKeys {
+			fileByKey[key] = fileList[i]
+		}
+		for i, item := range clusterList {
+			var fileItem any
+			if key, ok := ListKey(k, item); ok && fileKeyed {
+				fileItem = fileByKey[key]
+			} else if i < len(fileList) {
+				fileItem = fileList[i]
+			}
+			dropInjected(fileItem, item, injected)
+		}
+	}
+}
+
+// dropInjectedItems returns clusterList without elements having name matching patterns, which are missing in fileList
+func dropInjectedItems(fileList, clusterList []any, patterns []*regexp.Regexp) []any {
+	inFile := make(map[string]bool, len(fileList))
+	for _, item := range fileList {
+		if name, ok := itemName(item); ok {
+			inFile[name] = true
+		}
+	}
+	res := make([]any, 0, len(clusterList))
+	for _, item := range clusterList {
+		if name, ok := itemName(item); ok && !inFile[name] && matchesAny(patterns, name) {
+			continue
+		}
+		res = append(res, item)
+	}
+	return res
+}
+
+func itemName(item any) (string, bool) {
+	m, _ := item.(map[string]any)
+	name, ok := m["name"].(string)
+	return name, ok
+}
+
+func matchesAny(patterns []*regexp.Regexp, s string) bool {
+	for _, re := range patterns {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
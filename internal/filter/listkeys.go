@@ -0,0 +1,68 @@
+package filter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// listMapKeys are keys to match list elements by, like in strategic merge patch (x-kubernetes-list-map-keys).
+// Lists are found by field name, and first key set with present first key is used.
+var listMapKeys = map[string][][]string{
+	"containers":                {{"name"}},
+	"initContainers":            {{"name"}},
+	"ephemeralContainers":       {{"name"}},
+	"ports":                     {{"containerPort", "protocol"}, {"port", "protocol"}},
+	"env":                       {{"name"}},
+	"volumes":                   {{"name"}},
+	"volumeMounts":              {{"mountPath"}},
+	"volumeDevices":             {{"devicePath"}},
+	"imagePullSecrets":          {{"name"}},
+	"hostAliases":               {{"ip"}},
+	"topologySpreadConstraints": {{"topologyKey", "whenUnsatisfiable"}},
+	"resourceClaims":            {{"name"}},
+	"conditions":                {{"type"}},
+}
+
+// listKeyDefaults are values of keys which are defaulted by the api server, when missing
+var listKeyDefaults = map[string]any{
+	"protocol": "TCP",
+}
+
+// ListKey returns key of the list element, like "name=app" or "containerPort=80,protocol=TCP",
+// and false if elements of the list field have no keys to match by.
+func ListKey(field string, item any) (string, bool) {
+	m, ok := item.(map[string]any)
+	if !ok {
+		return "", false
+	}
+	for _, keys := range listMapKeys[field] {
+		if _, ok := m[keys[0]]; !ok {
+			continue
+		}
+		parts := make([]string, len(keys))
+		for i, k := range keys {
+			v, ok := m[k]
+			if !ok {
+				v = listKeyDefaults[k]
+			}
+			parts[i] = fmt.Sprintf("%s=%v", k, v)
+		}
+		return strings.Join(parts, ","), true
+	}
+	return "", false
+}
+
+// ListKeys returns keys of all the list elements, and false if any of them has no key or keys are not unique
+func ListKeys(field string, list []any) ([]string, bool) {
+	res := make([]string, len(list))
+	seen := make(map[string]bool, len(list))
+	for i, item := range list {
+		key, ok := ListKey(field, item)
+		if !ok || seen[key] {
+			return nil, false
+		}
+		seen[key] = true
+		res[i] = key
+	}
+	return res, true
+}
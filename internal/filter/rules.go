@@ -0,0 +1,285 @@
+package filter
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kyaml "sigs.k8s.io/yaml"
+)
+
+// Rules document in the filter file ignores fields by path, for matching objects:
+//
+//	apiVersion: kubediff/v1
+//	kind: Rules
+//	rules:
+//	- match: {group: apps, kind: Deployment, namespace: prod, name: "web-*"}
+//	  ignore:
+//	  - spec.template.spec.containers[*].resources
+//	  - metadata.annotations['argocd.*']
+//	- match: {kind: Service}
+//	  when: "object.spec.type == 'LoadBalancer'" # CEL predicate, with object, file and cluster variables
+//	  filter:                                     # applied like a filter document, after the one for the kind
+//	    spec:
+//	      externalTrafficPolicy: Cluster
+//	- match: {group: apps}
+//	  injected:                                   # cluster list elements by name, dropped when missing in file
+//	    containers: [istio-proxy, "vault-agent*"]
+//	    volumes: ["istio-*"]
+const (
+	rulesAPIVersion = "kubediff/v1"
+	rulesKind       = "Rules"
+)
+
+type rulesDoc struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Rules      []struct {
+		Match    ruleMatch           `json:"match"`
+		When     string              `json:"when"`
+		Ignore   []string            `json:"ignore"`
+		Filter   map[string]any      `json:"filter"`
+		Injected map[string][]string `json:"injected"`
+	} `json:"rules"`
+}
+
+// ruleMatch selects objects by glob patterns, unset field matches any value. Core api group is "".
+type ruleMatch struct {
+	Group     *string `json:"group"`
+	Kind      *string `json:"kind"`
+	Namespace *string `json:"namespace"`
+	Name      *string `json:"name"`
+}
+
+type rule struct {
+	match    [4]*regexp.Regexp // group, kind, namespace, name; nil matches any
+	when     *predicate        // nil matches any
+	ignore   [][]pathSegment
+	filter   map[string]any
+	injected map[string][]*regexp.Regexp // list field name to element name globs
+}
+
+// pathSegment is a map key glob, or a list index when key is nil (-1 for any element)
+type pathSegment struct {
+	key   *regexp.Regexp
+	index int
+}
+
+func isRulesDoc(obj *unstructured.Unstructured) bool {
+	return obj.GetAPIVersion() == rulesAPIVersion && obj.GetKind() == rulesKind
+}
+
+// parseRules compiles the rules document
+func parseRules(obj *unstructured.Unstructured) ([]rule, error) {
+	data, err := kyaml.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	var doc rulesDoc
+	if err := kyaml.UnmarshalStrict(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %w", err)
+	}
+
+	// filter is taken from the object itself, as numbers are int64 there like in compared objects, but float64 in doc
+	objRules, _ := obj.Object["rules"].([]any)
+	var res []rule
+	for i, r := range doc.Rules {
+		var compiled rule
+		if i < len(objRules) {
+			objRule, _ := objRules[i].(map[string]any)
+			r.Filter, _ = objRule["filter"].(map[string]any)
+		}
+		for i, m := range []*string{r.Match.Group, r.Match.Kind, r.Match.Namespace, r.Match.Name} {
+			if m != nil {
+				compiled.match[i] = globRegexp(*m)
+			}
+		}
+		if r.When != "" {
+			compiled.when, err = compilePredicate(r.When)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compile when %q: %w", r.When, err)
+			}
+		}
+		if err := validateHandlers(r.Filter, "filter"); err != nil {
+			return nil, err
+		}
+		compiled.filter = r.Filter
+		for field, names := range r.Injected {
+			if compiled.injected == nil {
+				compiled.injected = make(map[string][]*regexp.Regexp)
+			}
+			for _, n := range names {
+				compiled.injected[field] = append(compiled.injected[field], globRegexp(n))
+			}
+		}
+		for _, p := range r.Ignore {
+			path, err := parsePath(p)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse ignore path %q: %w", p, err)
+			}
+			compiled.ignore = append(compiled.ignore, path)
+		}
+		res = append(res, compiled)
+	}
+	return res, nil
+}
+
+// globRegexp converts glob pattern to regexp, * matches any characters including / and .
+func globRegexp(glob string) *regexp.Regexp {
+	re := regexp.QuoteMeta(glob)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	return regexp.MustCompile("^" + re + "$")
+}
+
+// parsePath parses dotted path with list indexes, like spec.containers[*].env[0] or metadata.labels['app.kubernetes.io/*']
+func parsePath(p string) ([]pathSegment, error) {
+	var res []pathSegment
+	for i := 0; i < len(p); {
+		switch {
+		case p[i] == '.' && i > 0:
+			i++
+			continue
+		case p[i] == '[' && i+1 < len(p) && (p[i+1] == '\'' || p[i+1] == '"'):
+			end := strings.Index(p[i+2:], string(p[i+1])+"]")
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed quote at %d", i+1)
+			}
+			res = append(res, pathSegment{key: globRegexp(p[i+2 : i+2+end])})
+			i += end + 4
+		case p[i] == '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed bracket at %d", i)
+			}
+			idx := p[i+1 : i+end]
+			seg := pathSegment{index: -1}
+			if idx != "*" {
+				n, err := strconv.Atoi(idx)
+				if err != nil || n < 0 {
+					return nil, fmt.Errorf("invalid index %q", idx)
+				}
+				seg.index = n
+			}
+			res = append(res, seg)
+			i += end + 1
+		default:
+			end := strings.IndexAny(p[i:], ".[")
+			if end < 0 {
+				end = len(p) - i
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty key at %d", i)
+			}
+			res = append(res, pathSegment{key: globRegexp(p[i : i+end])})
+			i += end
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("empty path")
+	}
+	return res, nil
+}
+
+// matches returns true if the object is selected by the rule
+func (r rule) matches(obj *unstructured.Unstructured, namespace string) bool {
+	for i, v := range []string{obj.GroupVersionKind().Group, obj.GetKind(), namespace, obj.GetName()} {
+		if r.match[i] != nil && !r.match[i].MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchingRules returns rules selecting the objects.
+// Rules are matched by fileObj, or by clusterObj when the object is missing in files.
+func (f Filter) matchingRules(fileObj, clusterObj *unstructured.Unstructured) []rule {
+	obj := fileObj
+	if len(obj.Object) == 0 {
+		obj = clusterObj
+	}
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		namespace = clusterObj.GetNamespace()
+	}
+
+	var res []rule
+	for _, r := range f.rules {
+		if !r.matches(obj, namespace) {
+			continue
+		}
+		if r.when != nil && !r.when.eval(obj, fileObj, clusterObj, f.warned) {
+			continue
+		}
+		res = append(res, r)
+	}
+	return res
+}
+
+// Ignore drops fields matching ignore rules from both objects, and injected list elements from clusterObj
+func (f Filter) Ignore(fileObj, clusterObj *unstructured.Unstructured) {
+	ignoreRules(f.matchingRules(fileObj, clusterObj), fileObj, clusterObj)
+}
+
+func ignoreRules(rules []rule, fileObj, clusterObj *unstructured.Unstructured) {
+	for _, r := range rules {
+		for _, path := range r.ignore {
+			ignorePath(fileObj.Object, path)
+			ignorePath(clusterObj.Object, path)
+		}
+		if r.injected != nil {
+			dropInjected(fileObj.Object, clusterObj.Object, r.injected)
+		}
+	}
+}
+
+// ignorePath removes the path from value, and returns updated value and true if it became empty because of that
+func ignorePath(v any, path []pathSegment) (any, bool) {
+	seg := path[0]
+	switch v := v.(type) {
+	case map[string]any:
+		if seg.key == nil {
+			return v, false
+		}
+		removed := false
+		for k, val := range v {
+			if !seg.key.MatchString(k) {
+				continue
+			}
+			if len(path) == 1 {
+				delete(v, k)
+				removed = true
+				continue
+			}
+			nv, empty := ignorePath(val, path[1:])
+			if empty {
+				delete(v, k)
+				removed = true
+				continue
+			}
+			v[k] = nv
+		}
+		return v, removed && len(v) == 0
+
+	case []any:
+		if seg.key != nil {
+			return v, false
+		}
+		res := make([]any, 0, len(v))
+		for i, val := range v {
+			if seg.index >= 0 && i != seg.index {
+				res = append(res, val)
+				continue
+			}
+			if len(path) == 1 {
+				continue
+			}
+			nv, _ := ignorePath(val, path[1:])
+			res = append(res, nv)
+		}
+		return res, len(res) == 0 && len(v) != 0
+	}
+	return v, false
+}
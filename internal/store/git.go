@@ -0,0 +1,126 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// GitRevision reads files as they are at some commit of the local git repository
+type GitRevision struct {
+	ref  string
+	root string // worktree root, to resolve local paths
+	tree *object.Tree
+}
+
+// OpenGitRevision finds git repository containing current directory, and resolves ref (branch, tag, commit) in it
+func OpenGitRevision(ref string) (*GitRevision, error) {
+	repo, err := git.PlainOpenWithOptions(".", &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open git repository: %w", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get git worktree: %w", err)
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", ref, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of %s: %w", ref, err)
+	}
+	root, err := filepath.EvalSymlinks(wt.Filesystem.Root())
+	if err != nil {
+		return nil, err
+	}
+	return &GitRevision{ref: ref, root: root, tree: tree}, nil
+}
+
+// repoPath converts local filename to path in the repository
+func (g *GitRevision) repoPath(filename string) (string, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	// resolve symlinks of the existing parent, as file itself may be missing in the worktree
+	dir, base := filepath.Dir(abs), filepath.Base(abs)
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(g.root, filepath.Join(dir, base))
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("%s is outside of git repository %s", filename, g.root)
+	}
+	if rel == "." {
+		return "", nil
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// ExpandToFilenames works like ExpandToFilenames for the files at the revision.
+// Returned names are local paths, missing files are skipped, as they may be added in the worktree.
+func (g *GitRevision) ExpandToFilenames(names []string, recursive bool) ([]string, error) {
+	var res []string
+	for _, filename := range names {
+		if filename == Stdin {
+			return nil, fmt.Errorf("can't read stdin at git revision %s", g.ref)
+		}
+		p, err := g.repoPath(filename)
+		if err != nil {
+			return nil, err
+		}
+		if p != "" {
+			if _, err := g.tree.File(p); err == nil {
+				res = append(res, filename)
+				continue
+			}
+		}
+		// dir
+		tree := g.tree
+		if p != "" {
+			if tree, err = g.tree.Tree(p); err != nil {
+				continue
+			}
+		}
+		if !recursive {
+			continue
+		}
+		err = tree.Files().ForEach(func(f *object.File) error {
+			if strings.HasSuffix(f.Name, ".yaml") || strings.HasSuffix(f.Name, ".yml") {
+				res = append(res, filepath.Join(filename, filepath.FromSlash(f.Name)))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// Open opens the local filename as it is at the revision. Returns the reader and the source name to use in reports.
+func (g *GitRevision) Open(filename string) (io.ReadCloser, string, error) {
+	p, err := g.repoPath(filename)
+	if err != nil {
+		return nil, filename, err
+	}
+	source := g.ref + ":" + path.Clean(p)
+	f, err := g.tree.File(p)
+	if err != nil {
+		return nil, source, err
+	}
+	r, err := f.Reader()
+	return r, source, err
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// HelmChart is a local chart directory or tarball to render
+type HelmChart struct {
+	Path        string
+	ValueFiles  []string
+	Release     string
+	Namespace   string
+	KubeVersion string   // cluster version for .Capabilities, helm default if empty
+	APIVersions []string // cluster api versions for .Capabilities, in addition to helm defaults
+}
+
+// Render renders the chart like `helm template`, and returns resulting objects along with the template file of each object.
+// Hooks are skipped, as they are not part of the release.
+func (c HelmChart) Render() ([]*unstructured.Unstructured, []string, error) {
+	chrt, err := loader.Load(c.Path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load chart %s: %w", c.Path, err)
+	}
+
+	vals := map[string]any{}
+	for _, fn := range c.ValueFiles {
+		v, err := chartutil.ReadValuesFile(fn)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read values %s: %w", fn, err)
+		}
+		vals = mergeMaps(vals, v)
+	}
+	if err := chartutil.ProcessDependenciesWithMerge(chrt, vals); err != nil {
+		return nil, nil, fmt.Errorf("failed to process chart dependencies: %w", err)
+	}
+
+	caps := *chartutil.DefaultCapabilities
+	// copy to not append to the backing array of the global defaults
+	caps.APIVersions = append(slices.Clone(caps.APIVersions), c.APIVersions...)
+	if c.KubeVersion != "" {
+		kv, err := chartutil.ParseKubeVersion(c.KubeVersion)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse kube version %s: %w", c.KubeVersion, err)
+		}
+		caps.KubeVersion = *kv
+	}
+	options := chartutil.ReleaseOptions{Name: c.Release, Namespace: c.Namespace, Revision: 1, IsInstall: true}
+	renderVals, err := chartutil.ToRenderValues(chrt, vals, options, &caps)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to prepare values: %w", err)
+	}
+
+	files, err := engine.Render(chrt, renderVals)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to render chart: %w", err)
+	}
+	for fn := range files {
+		if strings.HasSuffix(fn, "NOTES.txt") {
+			delete(files, fn)
+		}
+	}
+	_, manifests, err := releaseutil.SortManifests(files, caps.APIVersions, releaseutil.InstallOrder)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse rendered manifests: %w", err)
+	}
+
+	var objs []*unstructured.Unstructured
+	var sources []string
+	for _, m := range manifests {
+		for obj := range YamlToObj(strings.NewReader(m.Content)) {
+			if obj == nil {
+				return nil, nil, fmt.Errorf("failed to decode YAML from %s", m.Name)
+			}
+			objs = append(objs, obj)
+			sources = append(sources, m.Name)
+		}
+	}
+	return objs, sources, nil
+}
+
+// mergeMaps deep merges b into a, same as helm does for multiple values files
+func mergeMaps(a, b map[string]any) map[string]any {
+	out := make(map[string]any, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]any); ok {
+			if bv, ok := out[k].(map[string]any); ok {
+				out[k] = mergeMaps(bv, v)
+				continue
+			}
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/resource"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	kyaml "sigs.k8s.io/yaml"
+)
+
+// Kustomize builds kustomization in dir, and returns resulting objects along with the source file of each object
+func Kustomize(dir string) ([]*unstructured.Unstructured, []string, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if root, err = filepath.EvalSymlinks(root); err != nil {
+		return nil, nil, err
+	}
+
+	k := krusty.MakeKustomizer(krusty.MakeDefaultOptions())
+	m, err := k.Run(originFS{FileSystem: filesys.MakeFsOnDisk(), root: root}, root)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build kustomization %s: %w", dir, err)
+	}
+
+	var objs []*unstructured.Unstructured
+	var sources []string
+	for _, r := range m.Resources() {
+		origin, err := r.GetOrigin()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get origin of %s: %w", r.CurId(), err)
+		}
+		sources = append(sources, originSource(dir, origin))
+	}
+	if err := m.RemoveOriginAnnotations(); err != nil {
+		return nil, nil, err
+	}
+	for _, r := range m.Resources() {
+		// decode via json like other inputs, as r.Map() has int numbers instead of int64
+		data, err := r.MarshalJSON()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to convert %s: %w", r.CurId(), err)
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to convert %s: %w", r.CurId(), err)
+		}
+		objs = append(objs, obj)
+	}
+	return objs, sources, nil
+}
+
+// originSource returns file name of the object's origin, relative to kustomization dir
+func originSource(dir string, origin *resource.Origin) string {
+	switch {
+	case origin == nil:
+		return dir
+	case origin.Repo != "":
+		return origin.Repo + "//" + origin.Path
+	case origin.Path != "":
+		return filepath.Join(dir, origin.Path)
+	case origin.ConfiguredIn != "":
+		return filepath.Join(dir, origin.ConfiguredIn)
+	}
+	return dir
+}
+
+// originFS enables origin annotations for the top-level kustomization, without changing it on disk
+type originFS struct {
+	filesys.FileSystem
+	root string
+}
+
+func (fs originFS) ReadFile(path string) ([]byte, error) {
+	data, err := fs.FileSystem.ReadFile(path)
+	if err != nil || filepath.Dir(path) != fs.root || !slices.Contains(konfig.RecognizedKustomizationFileNames(), filepath.Base(path)) {
+		return data, err
+	}
+
+	var k map[string]any
+	if err := kyaml.Unmarshal(data, &k); err != nil || k == nil {
+		return data, nil // let kustomize report the error
+	}
+	meta, _ := k["buildMetadata"].([]any)
+	if !slices.Contains(meta, any(types.OriginAnnotations)) {
+		k["buildMetadata"] = append(meta, types.OriginAnnotations)
+	}
+	return kyaml.Marshal(k)
+}
@@ -0,0 +1,336 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"go.yaml.in/yaml/v3"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// encrypted value format: ENC[AES256_GCM,data:...,iv:...,tag:...,type:str]
+var sopsValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:(.*),iv:(.*),tag:(.*),type:(.*)\]$`)
+
+// Sops decrypts objects encrypted by SOPS with age keys
+type Sops struct {
+	KeyFile    string // age identities file, defaults like in sops
+	identities []age.Identity
+}
+
+// IsSopsEncrypted returns true if the object has sops metadata
+func IsSopsEncrypted(obj *unstructured.Unstructured) bool {
+	_, ok := obj.Object["sops"].(map[string]any)
+	return ok
+}
+
+// loadIdentities reads age keys from KeyFile, or SOPS_AGE_KEY_FILE, SOPS_AGE_KEY env, or sops default keys.txt location
+func (s *Sops) loadIdentities() error {
+	if s.identities != nil {
+		return nil
+	}
+	var r io.Reader
+	fn := s.KeyFile
+	if fn == "" {
+		fn = os.Getenv("SOPS_AGE_KEY_FILE")
+	}
+	if key := os.Getenv("SOPS_AGE_KEY"); fn == "" && key != "" {
+		r = strings.NewReader(key)
+	} else {
+		if fn == "" {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				return fmt.Errorf("no age key file specified: %w", err)
+			}
+			fn = filepath.Join(dir, "sops", "age", "keys.txt")
+		}
+		data, err := os.ReadFile(fn)
+		if err != nil {
+			return fmt.Errorf("failed to read age key file: %w", err)
+		}
+		r = bytes.NewReader(data)
+	}
+
+	ids, err := age.ParseIdentities(r)
+	if err != nil {
+		return fmt.Errorf("failed to parse age keys: %w", err)
+	}
+	s.identities = ids
+	return nil
+}
+
+// Decrypt decrypts values of sops encrypted object in place, and removes sops metadata.
+// Returns paths of map keys to the decrypted values, so they could be masked in output.
+// MAC is not checked here, as it covers all the documents of the file in original order, see VerifyMAC.
+func (s *Sops) Decrypt(obj *unstructured.Unstructured) ([][]string, error) {
+	meta, ok := obj.Object["sops"].(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	if err := s.loadIdentities(); err != nil {
+		return nil, err
+	}
+	key, err := s.dataKey(meta)
+	if err != nil {
+		return nil, err
+	}
+	delete(obj.Object, "sops")
+
+	var paths [][]string
+	tree, err := decryptValue(key, obj.Object, nil, &paths)
+	if err != nil {
+		return nil, err
+	}
+	obj.Object = tree.(map[string]any)
+	return paths, nil
+}
+
+// VerifyMAC checks MAC of sops encrypted file, docs are YAML documents of the file in order.
+// MAC is SHA512 of all the values in order of the documents, same as sops computes it, so tampered files are rejected.
+func (s *Sops) VerifyMAC(docs [][]byte) error {
+	nodes := make([]*yaml.Node, len(docs))
+	for i, doc := range docs {
+		nodes[i] = &yaml.Node{}
+		if err := yaml.Unmarshal(doc, nodes[i]); err != nil {
+			return fmt.Errorf("failed to parse YAML: %w", err)
+		}
+	}
+	var meta map[string]any
+	if len(nodes) > 0 {
+		if n := sopsNode(nodes[0]); n != nil {
+			if err := n.Decode(&meta); err != nil {
+				return fmt.Errorf("failed to parse sops metadata: %w", err)
+			}
+		}
+	}
+	if meta == nil {
+		return errors.New("sops metadata not found")
+	}
+	if err := s.loadIdentities(); err != nil {
+		return err
+	}
+	key, err := s.dataKey(meta)
+	if err != nil {
+		return err
+	}
+
+	mac, _ := meta["mac"].(string)
+	m := sopsValue.FindStringSubmatch(mac)
+	if m == nil {
+		return errors.New("sops MAC is missing")
+	}
+	lastModified, _ := meta["lastmodified"].(string)
+	t, err := time.Parse(time.RFC3339, lastModified)
+	if err != nil {
+		return fmt.Errorf("failed to parse sops lastmodified: %w", err)
+	}
+	expected, err := decryptBytes(key, m[1:], t.Format(time.RFC3339))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt sops MAC: %w", err)
+	}
+
+	onlyEncrypted, _ := meta["mac_only_encrypted"].(bool)
+	h := sha512.New()
+	if onlyEncrypted {
+		// sops prefixes such MAC with sha256 of "sops", so it differs from MAC of all the values
+		prefix := sha256.Sum256([]byte("sops"))
+		h.Write(prefix[:])
+	}
+	for _, n := range nodes {
+		if err := hashValues(key, n, nil, onlyEncrypted, h); err != nil {
+			return err
+		}
+	}
+	if fmt.Sprintf("%X", h.Sum(nil)) != string(expected) {
+		return errors.New("sops MAC mismatch, file is modified after encryption")
+	}
+	return nil
+}
+
+// sopsNode returns value of the top level sops key of the document
+func sopsNode(doc *yaml.Node) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	m := doc.Content[0]
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == "sops" {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// hashValues walks the YAML tree in order, skipping top level sops metadata, and writes plaintext values to h.
+// Plain values are written as sops stores them: booleans as True/False, numbers in canonical form, nulls are skipped.
+func hashValues(key []byte, n *yaml.Node, path []string, onlyEncrypted bool, h hash.Hash) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, c := range n.Content {
+			if err := hashValues(key, c, path, onlyEncrypted, h); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i].Value
+			if path == nil && k == "sops" {
+				continue
+			}
+			if err := hashValues(key, n.Content[i+1], append(path, k), onlyEncrypted, h); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for _, c := range n.Content {
+			if err := hashValues(key, c, path, onlyEncrypted, h); err != nil {
+				return err
+			}
+		}
+	case yaml.AliasNode:
+		return hashValues(key, n.Alias, path, onlyEncrypted, h)
+	case yaml.ScalarNode:
+		if m := sopsValue.FindStringSubmatch(n.Value); m != nil {
+			plain, err := decryptBytes(key, m[1:], strings.Join(path, ":")+":")
+			if err != nil {
+				return fmt.Errorf("failed to decrypt %s: %w", strings.Join(path, "."), err)
+			}
+			h.Write(plain)
+		} else if !onlyEncrypted {
+			h.Write(scalarBytes(n))
+		}
+	}
+	return nil
+}
+
+// scalarBytes returns plain value the way sops converts it for MAC
+func scalarBytes(n *yaml.Node) []byte {
+	switch n.ShortTag() {
+	case "!!null":
+		return nil
+	case "!!bool":
+		var b bool
+		if n.Decode(&b) == nil {
+			if b {
+				return []byte("True")
+			}
+			return []byte("False")
+		}
+	case "!!int":
+		var i int
+		if n.Decode(&i) == nil {
+			return []byte(strconv.Itoa(i))
+		}
+	case "!!float":
+		var f float64
+		if n.Decode(&f) == nil {
+			return []byte(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+	}
+	return []byte(n.Value)
+}
+
+// dataKey decrypts the data key with one of age identities
+func (s *Sops) dataKey(meta map[string]any) ([]byte, error) {
+	recipients, _ := meta["age"].([]any)
+	if len(recipients) == 0 {
+		return nil, errors.New("sops data key is not encrypted with age, other key types are not supported")
+	}
+	var errs []error
+	for _, r := range recipients {
+		enc, _ := r.(map[string]any)["enc"].(string)
+		rd, err := age.Decrypt(armor.NewReader(strings.NewReader(enc)), s.identities...)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		return io.ReadAll(rd)
+	}
+	return nil, fmt.Errorf("failed to decrypt sops data key: %w", errors.Join(errs...))
+}
+
+// decryptValue walks the tree and decrypts ENC[] values, path of map keys is authenticated along with the value.
+// Paths of decrypted values are appended to paths.
+func decryptValue(key []byte, v any, path []string, paths *[][]string) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, val := range v {
+			res, err := decryptValue(key, val, append(path, k), paths)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = res
+		}
+	case []any:
+		for i, val := range v {
+			res, err := decryptValue(key, val, path, paths)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = res
+		}
+	case string:
+		if m := sopsValue.FindStringSubmatch(v); m != nil {
+			res, err := decryptString(key, m[1:], strings.Join(path, ":")+":")
+			if err != nil {
+				return nil, fmt.Errorf("failed to decrypt %s: %w", strings.Join(path, "."), err)
+			}
+			*paths = append(*paths, slices.Clone(path))
+			return res, nil
+		}
+	}
+	return v, nil
+}
+
+// decryptString decrypts the value and converts the result to the type
+func decryptString(key []byte, parts []string, aad string) (any, error) {
+	plain, err := decryptBytes(key, parts, aad)
+	if err != nil {
+		return nil, err
+	}
+	switch parts[3] {
+	case "int":
+		return strconv.ParseInt(string(plain), 10, 64)
+	case "float":
+		return strconv.ParseFloat(string(plain), 64)
+	case "bool":
+		return strconv.ParseBool(string(plain))
+	}
+	return string(plain), nil
+}
+
+// decryptBytes decrypts AES-GCM encrypted data, iv, tag
+func decryptBytes(key []byte, parts []string, aad string) ([]byte, error) {
+	var raw [3][]byte
+	for i := range raw {
+		var err error
+		if raw[i], err = base64.StdEncoding.DecodeString(parts[i]); err != nil {
+			return nil, err
+		}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(raw[1]))
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Open(nil, raw[1], append(raw[0], raw[2]...), []byte(aad))
+}
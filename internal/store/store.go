@@ -11,6 +11,22 @@ import (
 	"strings"
 )
 
+// Stdin is the filename to read manifests from standard input
+const Stdin = "-"
+
+// Open opens the file for reading, "-" means standard input. Returns the reader and the source name to use in reports.
+func Open(filename string) (io.ReadCloser, string, error) {
+	return open(filename, os.Stdin)
+}
+
+func open(filename string, stdin io.Reader) (io.ReadCloser, string, error) {
+	if filename == Stdin {
+		return io.NopCloser(stdin), "<stdin>", nil
+	}
+	f, err := os.Open(filename)
+	return f, filename, err
+}
+
 func YamlToObj(r io.Reader) chan *unstructured.Unstructured {
 	ch := make(chan *unstructured.Unstructured)
 	go func() {
@@ -42,6 +58,10 @@ func ExpandToFilenames(names []string, recursive bool) ([]string, error) {
 	var res []string
 
 	for _, filename := range names {
+		if filename == Stdin {
+			res = append(res, filename)
+			continue
+		}
 		stat, err := os.Stat(filename)
 		if err != nil {
 			return nil, fmt.Errorf("failed to stat %s: %w", filename, err)
@@ -0,0 +1,408 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestKustomize(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base/kustomization.yaml": "resources:\n- cm.yaml\n",
+		"base/cm.yaml":            "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\ndata:\n  a: b\n",
+		"overlay/kustomization.yaml": `namespace: test
+resources:
+- ../base
+- svc.yaml
+configMapGenerator:
+- name: gen
+  literals: [x=y]
+  options:
+    disableNameSuffixHash: true
+`,
+		"overlay/svc.yaml": "apiVersion: v1\nkind: Service\nmetadata:\n  name: svc\nspec:\n  ports: [{port: 80}]\n",
+	}
+	for fn, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	overlay := filepath.Join(dir, "overlay")
+	objs, sources, err := Kustomize(overlay)
+	if err != nil {
+		t.Fatalf("Kustomize() unexpected error = %v", err)
+	}
+
+	var names []string
+	for _, obj := range objs {
+		names = append(names, obj.GetNamespace()+"/"+obj.GetName())
+		if len(obj.GetAnnotations()) != 0 {
+			t.Errorf("Kustomize() expected origin annotation to be removed, got %v", obj.GetAnnotations())
+		}
+	}
+	expectedNames := []string{"test/cm", "test/svc", "test/gen"}
+	if !reflect.DeepEqual(names, expectedNames) {
+		t.Errorf("Kustomize() objects = %v, expected %v", names, expectedNames)
+	}
+	expectedSources := []string{
+		filepath.Join(overlay, "../base/cm.yaml"),
+		filepath.Join(overlay, "svc.yaml"),
+		filepath.Join(overlay, "kustomization.yaml"),
+	}
+	if !reflect.DeepEqual(sources, expectedSources) {
+		t.Errorf("Kustomize() sources = %v, expected %v", sources, expectedSources)
+	}
+}
+
+func TestHelmChartRender(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml":            "apiVersion: v2\nname: test\nversion: 0.1.0\n",
+		"values.yaml":           "value: default\nnested:\n  a: 1\n  b: 2\n",
+		"templates/NOTES.txt":   "Installed {{ .Release.Name }}",
+		"templates/_helper.tpl": `{{- define "name" }}{{ .Release.Name }}-cm{{ end }}`,
+		"templates/cm.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: {{ include "name" . }}
+  namespace: {{ .Release.Namespace }}
+data:
+  value: {{ .Values.value }}
+  a: "{{ .Values.nested.a }}"
+  b: "{{ .Values.nested.b }}"
+`,
+		"templates/hook.yaml": `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: hook
+  annotations:
+    helm.sh/hook: pre-install
+`,
+		"override.yaml": "value: override\nnested:\n  b: 3\n",
+	}
+	for fn, data := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, fn)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fn), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chart := HelmChart{Path: dir, ValueFiles: []string{filepath.Join(dir, "override.yaml")}, Release: "rel", Namespace: "ns"}
+	objs, sources, err := chart.Render()
+	if err != nil {
+		t.Fatalf("Render() unexpected error = %v", err)
+	}
+	if len(objs) != 1 {
+		t.Fatalf("Render() expected 1 object, got %d", len(objs))
+	}
+	if objs[0].GetName() != "rel-cm" || objs[0].GetNamespace() != "ns" {
+		t.Errorf("Render() unexpected object %s/%s", objs[0].GetNamespace(), objs[0].GetName())
+	}
+	expectedData := map[string]any{"value": "override", "a": "1", "b": "3"}
+	if !reflect.DeepEqual(objs[0].Object["data"], expectedData) {
+		t.Errorf("Render() data = %v, expected %v", objs[0].Object["data"], expectedData)
+	}
+	if sources[0] != "test/templates/cm.yaml" {
+		t.Errorf("Render() source = %s, expected test/templates/cm.yaml", sources[0])
+	}
+}
+
+func TestGitRevision(t *testing.T) {
+	dir := t.TempDir()
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for fn, content := range map[string]string{"m/a.yaml": "old", "m/sub/b.yml": "b", "m/readme.md": "skip"} {
+		full := filepath.Join(dir, fn)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(fn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := wt.Commit("init", &git.CommitOptions{Author: &object.Signature{Name: "test", When: time.Now()}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "m/a.yaml"), []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(filepath.Join(dir, "m"))
+
+	g, err := OpenGitRevision("HEAD")
+	if err != nil {
+		t.Fatalf("OpenGitRevision() unexpected error = %v", err)
+	}
+	names, err := g.ExpandToFilenames([]string{"."}, true)
+	if err != nil {
+		t.Fatalf("ExpandToFilenames() unexpected error = %v", err)
+	}
+	if expected := []string{"a.yaml", filepath.Join("sub", "b.yml")}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("ExpandToFilenames() = %v, expected %v", names, expected)
+	}
+
+	r, source, err := g.Open("a.yaml")
+	if err != nil {
+		t.Fatalf("Open() unexpected error = %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "old" || source != "HEAD:m/a.yaml" {
+		t.Errorf("Open() = %q from %s, expected %q from HEAD:m/a.yaml", data, source, "old")
+	}
+}
+
+func TestSopsDecrypt(t *testing.T) {
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(keyFile, []byte("# test key\n"+id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	var encKey bytes.Buffer
+	aw := armor.NewWriter(&encKey)
+	w, err := age.Encrypt(aw, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(dataKey); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	aw.Close()
+
+	// same as sops does
+	encrypt := func(value, typ, path string) string {
+		block, _ := aes.NewCipher(dataKey)
+		gcm, _ := cipher.NewGCMWithNonceSize(block, 32)
+		iv := make([]byte, 32)
+		if _, err := rand.Read(iv); err != nil {
+			t.Fatal(err)
+		}
+		out := gcm.Seal(nil, iv, []byte(value), []byte(path))
+		enc := base64.StdEncoding.EncodeToString
+		return fmt.Sprintf("ENC[AES256_GCM,data:%s,iv:%s,tag:%s,type:%s]", enc(out[:len(out)-16]), enc(iv), enc(out[len(out)-16:]), typ)
+	}
+	// values in order of the document, as sops hashes them for MAC
+	password := encrypt("secret", "str", "stringData:password:")
+	number := encrypt("7", "int", "stringData:list:")
+	encDoc := func(name, mac string) []byte {
+		return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: %s
+stringData:
+  password: %s
+  list:
+  - %s
+  debug: true
+sops:
+  age:
+  - recipient: %s
+    enc: |
+%s
+  lastmodified: "2024-01-02T03:04:05Z"
+  mac: %s
+`, name, password, number, id.Recipient(), indent(encKey.String(), "      "), mac))
+	}
+	sum := sha512.Sum512([]byte("v1" + "Secret" + "s" + "secret" + "7" + "True"))
+	mac := encrypt(fmt.Sprintf("%X", sum), "str", "2024-01-02T03:04:05Z")
+
+	s := &Sops{KeyFile: keyFile}
+	tests := []struct {
+		name    string
+		doc     []byte
+		wantErr bool
+	}{
+		{name: "valid", doc: encDoc("s", mac)},
+		{name: "tampered value", doc: encDoc("t", mac), wantErr: true},
+		{name: "tampered MAC", doc: encDoc("s", encrypt(strings.Repeat("0", 128), "str", "2024-01-02T03:04:05Z")), wantErr: true},
+		{name: "missing MAC", doc: bytes.Replace(encDoc("s", mac), []byte("  mac: "), []byte("  other: "), 1), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.VerifyMAC([][]byte{tt.doc})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VerifyMAC() error = %v, expected error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	obj := <-YamlToObj(bytes.NewReader(encDoc("s", mac)))
+	if !IsSopsEncrypted(obj) {
+		t.Fatal("IsSopsEncrypted() = false, expected true")
+	}
+	paths, err := s.Decrypt(obj)
+	if err != nil {
+		t.Fatalf("Decrypt() unexpected error = %v", err)
+	}
+	expected := map[string]any{"password": "secret", "list": []any{int64(7)}, "debug": true}
+	if !reflect.DeepEqual(obj.Object["stringData"], expected) {
+		t.Errorf("Decrypt() stringData = %v, expected %v", obj.Object["stringData"], expected)
+	}
+	slices.SortFunc(paths, slices.Compare)
+	expectedPaths := [][]string{{"stringData", "list"}, {"stringData", "password"}}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("Decrypt() paths = %v, expected %v", paths, expectedPaths)
+	}
+	if IsSopsEncrypted(obj) {
+		t.Error("Decrypt() sops metadata is not removed")
+	}
+
+	obj.Object["sops"] = map[string]any{"age": []any{map[string]any{"enc": encKey.String()}}}
+	obj.Object["data"] = map[string]any{"tampered": encrypt("x", "str", "data:other:")}
+	if _, err := s.Decrypt(obj); err == nil {
+		t.Error("Decrypt() expected error for wrong path, got nil")
+	}
+}
+
+// testdata/sops files are encrypted by sops 3.10.2 with the age key from keys.txt
+func TestSopsFiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		plain     string
+		tamperMAC bool // whether changes of unencrypted values break MAC
+	}{
+		{name: "encrypted_regex", file: "secret.enc.yaml", plain: "secret.yaml", tamperMAC: true},
+		{name: "mac_only_encrypted", file: "mac-only-encrypted.enc.yaml", plain: "secret.yaml"},
+		{name: "multiple documents", file: "multi.enc.yaml", plain: "multi.yaml", tamperMAC: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Sops{KeyFile: filepath.Join("testdata", "sops", "keys.txt")}
+			data, err := os.ReadFile(filepath.Join("testdata", "sops", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs := bytes.Split(data, []byte("\n---\n"))
+			if err := s.VerifyMAC(docs); err != nil {
+				t.Errorf("VerifyMAC() unexpected error = %v", err)
+			}
+			tampered := slices.Clone(docs)
+			tampered[0] = bytes.Replace(tampered[0], []byte("    name: "), []byte("    name: x"), 1)
+			if err := s.VerifyMAC(tampered); (err != nil) != tt.tamperMAC {
+				t.Errorf("VerifyMAC() tampered error = %v, expected error %v", err, tt.tamperMAC)
+			}
+			if len(docs) > 1 {
+				if err := s.VerifyMAC(docs[:1]); err == nil {
+					t.Error("VerifyMAC() expected error for missing document, got nil")
+				}
+			}
+
+			plain, err := os.ReadFile(filepath.Join("testdata", "sops", tt.plain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var expected []map[string]any
+			for obj := range YamlToObj(bytes.NewReader(plain)) {
+				expected = append(expected, obj.Object)
+			}
+			var got []map[string]any
+			for obj := range YamlToObj(bytes.NewReader(data)) {
+				if _, err := s.Decrypt(obj); err != nil {
+					t.Fatalf("Decrypt() unexpected error = %v", err)
+				}
+				got = append(got, obj.Object)
+			}
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("Decrypt() = %v, expected %v", got, expected)
+			}
+		})
+	}
+}
+
+func indent(s, prefix string) string {
+	return prefix + strings.ReplaceAll(strings.TrimSuffix(s, "\n"), "\n", "\n"+prefix)
+}
+
+func TestOpen(t *testing.T) {
+	manifest := "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: cm\n---\n# empty document\n"
+	fn := filepath.Join(t.TempDir(), "cm.yaml")
+	if err := os.WriteFile(fn, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		filename string
+		source   string
+		wantErr  bool
+	}{
+		{name: "stdin", filename: Stdin, source: "<stdin>"},
+		{name: "path", filename: fn, source: fn},
+		{name: "missing", filename: filepath.Join(t.TempDir(), "missing.yaml"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, source, err := open(tt.filename, bytes.NewBufferString(manifest))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			defer r.Close()
+			if source != tt.source {
+				t.Errorf("open() source = %q, expected %q", source, tt.source)
+			}
+			var names []string
+			for obj := range YamlToObj(r) {
+				if obj == nil {
+					t.Fatal("YamlToObj() failed to decode")
+				}
+				names = append(names, obj.GetName())
+			}
+			if !reflect.DeepEqual(names, []string{"cm"}) {
+				t.Errorf("YamlToObj() = %v, expected [cm]", names)
+			}
+		})
+	}
+
+	files, err := ExpandToFilenames([]string{Stdin, fn}, false)
+	if err != nil || !reflect.DeepEqual(files, []string{Stdin, fn}) {
+		t.Errorf("ExpandToFilenames() = %v, %v, expected [- %s]", files, err, fn)
+	}
+}